@@ -0,0 +1,218 @@
+package eventstore
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+)
+
+// Aggregate is the state of an entity, rebuilt by applying the entity's events in order. Aggregates embed
+// an AggregateRoot, which keeps track of their identity, version, and pending changes:
+//
+//     type Account struct {
+//         eventstore.AggregateRoot
+//         balance int
+//     }
+//
+//     func (a *Account) Apply(event eventstore.Event) error {
+//         // update balance from event
+//     }
+//
+//     func (a *Account) Deposit(amount int) error {
+//         // validate, then record the change
+//         return eventstore.Raise(a, &Deposited{ID: a.ID(), Amount: amount})
+//     }
+//
+// Apply must only update state: it is called both for new changes and for stored events when the
+// aggregate is loaded, so it must not fail on events that were valid when first raised.
+type Aggregate interface {
+	Apply(event Event) error
+	Root() *AggregateRoot
+}
+
+// AggregateRoot holds the bookkeeping shared by all aggregates. It is meant to be embedded.
+type AggregateRoot struct {
+	id      string
+	version int
+	stamp   Timestamp // of the last stored event applied
+	changes []Event
+}
+
+// Root returns r, so that types embedding an AggregateRoot implement Aggregate's Root method.
+func (r *AggregateRoot) Root() *AggregateRoot {
+	return r
+}
+
+// ID returns the aggregate's entity ID.
+func (r *AggregateRoot) ID() string {
+	return r.id
+}
+
+// Version returns the number of stored events the aggregate has been rebuilt from, or saved with.
+func (r *AggregateRoot) Version() int {
+	return r.version
+}
+
+// Changes returns the events raised since the aggregate was loaded or last saved.
+func (r *AggregateRoot) Changes() []Event {
+	return r.changes
+}
+
+// Raise applies a new event to an aggregate, and records it as a pending change, to be persisted by
+// Repository.Save. The event must belong to the aggregate's entity.
+func Raise(a Aggregate, event Event) error {
+	r := a.Root()
+	if err := validateEvent(event); err != nil {
+		return err
+	}
+	if event.EntityID() != r.id {
+		return fmt.Errorf("%w: event of entity `%s` raised on `%s`", ErrInvalidEvent, event.EntityID(), r.id)
+	}
+	if err := a.Apply(event); err != nil {
+		return err
+	}
+	r.changes = append(r.changes, event)
+	return nil
+}
+
+// Repository loads and saves aggregates of type A from a Dispatcher's entity streams.
+type Repository[A Aggregate] struct {
+	d         *Dispatcher
+	new       func() A
+	snapshots *SnapshotStore
+	policy    SnapshotPolicy
+}
+
+// RepositoryOption configures a Repository.
+type RepositoryOption func(*repositoryOptions)
+
+type repositoryOptions struct {
+	snapshots *SnapshotStore
+	policy    SnapshotPolicy
+}
+
+// WithSnapshots loads aggregates from their latest snapshot in store, and snapshots them on Save according
+// to policy. Aggregates must implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+func WithSnapshots(store *SnapshotStore, policy SnapshotPolicy) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.snapshots = store
+		o.policy = policy
+	}
+}
+
+// NewRepository creates a Repository, which uses newAggregate to create the empty aggregates that stored
+// events are applied to.
+func NewRepository[A Aggregate](d *Dispatcher, newAggregate func() A, opts ...RepositoryOption) *Repository[A] {
+	o := repositoryOptions{policy: SnapshotOnDemand}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Repository[A]{d: d, new: newAggregate, snapshots: o.snapshots, policy: o.policy}
+}
+
+// Load rebuilds an aggregate by applying all stored events of its entity, in order, or only those after
+// its latest snapshot if snapshots are enabled. Entities without events load as an empty aggregate at
+// version 0, ready to record their first changes.
+//
+// A snapshot only accounts for the events stamped before it, so if events with older stamps were ingested
+// since it was taken, the snapshot is discarded and the whole stream is replayed instead.
+func (r *Repository[A]) Load(ctx context.Context, id string) (A, error) {
+	a := r.new()
+	a.Root().id = id
+	u, ok := any(a).(encoding.BinaryUnmarshaler)
+	if !ok || r.snapshots == nil {
+		return a, r.replay(ctx, a, r.d.Events().ForEntity(id))
+	}
+	// Read the stored version first, so that events appended concurrently can only make the replay longer
+	stored, err := r.d.Version(ctx, id)
+	if err != nil {
+		return a, err
+	}
+	snap, err := r.snapshots.restore(id, u)
+	if err != nil {
+		return a, err
+	}
+	if snap.Version == 0 {
+		return a, r.replay(ctx, a, r.d.Events().ForEntity(id))
+	}
+	root := a.Root()
+	root.version, root.stamp = snap.Version, snap.Stamp
+	if err := r.replay(ctx, a, r.d.Events().ForEntity(id).After(snap.Stamp)); err != nil {
+		return a, err
+	}
+	if root.version >= stored {
+		return a, nil
+	}
+	a = r.new()
+	a.Root().id = id
+	return a, r.replay(ctx, a, r.d.Events().ForEntity(id))
+}
+
+// Snapshot stores the current state of a saved aggregate, so that later loads start from it. Pending
+// changes are not part of the snapshot, so the aggregate should be saved first.
+func (r *Repository[A]) Snapshot(a A) error {
+	if r.snapshots == nil {
+		return fmt.Errorf("repository has no snapshot store")
+	}
+	m, ok := any(a).(encoding.BinaryMarshaler)
+	if !ok {
+		return fmt.Errorf("aggregate %T does not implement encoding.BinaryMarshaler", a)
+	}
+	root := a.Root()
+	if len(root.changes) > 0 {
+		return fmt.Errorf("aggregate `%s` has unsaved changes", root.id)
+	}
+	return r.snapshots.Take(root.id, root.version, root.stamp, m)
+}
+
+// replay applies the events of q to a, advancing its version.
+func (r *Repository[A]) replay(ctx context.Context, a A, q EventQuery) error {
+	root := a.Root()
+	it := q.Iter(ctx)
+	defer it.Close()
+	for it.Next() {
+		if err := a.Apply(it.Event().Event); err != nil {
+			return fmt.Errorf("applying event %s of `%s`: %w", it.Event().Stamp, root.id, err)
+		}
+		root.version++
+		root.stamp = it.Event().Stamp
+	}
+	return it.Err()
+}
+
+// Save persists an aggregate's pending changes, provided no other events were stored for its entity since
+// it was loaded. Otherwise, nothing is written and an error wrapping ErrVersionConflict is returned, in
+// which case the aggregate should be loaded again and the command retried. If the snapshot policy says so,
+// the aggregate is then snapshotted.
+func (r *Repository[A]) Save(ctx context.Context, a A) error {
+	root := a.Root()
+	if len(root.changes) == 0 {
+		return nil
+	}
+	envs, err := r.d.Append(ctx, root.id, root.version, root.changes...)
+	if envs == nil {
+		return err
+	}
+	// Changes are persisted even if a reducer failed. Retried changes may return the envelopes of events
+	// the aggregate was loaded with, which are already counted in its version.
+	from := root.version
+	loaded := root.stamp
+	counted := make(map[Timestamp]bool)
+	for _, env := range envs {
+		if loaded.Less(env.Stamp) && !counted[env.Stamp] {
+			counted[env.Stamp] = true
+			root.version++
+		}
+		if root.stamp.Less(env.Stamp) {
+			root.stamp = env.Stamp
+		}
+	}
+	root.changes = nil
+	if err != nil {
+		return err
+	}
+	if r.snapshots != nil && r.policy != nil && r.policy(from, root.version) {
+		return r.Snapshot(a)
+	}
+	return nil
+}
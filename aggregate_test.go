@@ -0,0 +1,131 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// counter is an aggregate counting increments, which refuses to go over a limit.
+type counter struct {
+	AggregateRoot
+	count int
+}
+
+func (c *counter) Apply(event Event) error {
+	if event.Type() == "Incremented" {
+		c.count++
+	}
+	return nil
+}
+
+func (c *counter) Increment() error {
+	if c.count >= 3 {
+		return fmt.Errorf("limit reached")
+	}
+	return Raise(c, &testEvent{ID: c.ID(), Kind: "Incremented", Timestamp: time.Now()})
+}
+
+func newCounter() *counter {
+	return &counter{}
+}
+
+func TestRepository(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	repo := NewRepository(dispatcher, newCounter)
+	ctx := context.Background()
+	c, err := repo.Load(ctx, "c1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if c.ID() != "c1" || c.Version() != 0 {
+		t.Fatalf("unexpected new aggregate %+v", c)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Increment(); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if len(c.Changes()) != 2 || c.count != 2 {
+		t.Fatal("expected two pending changes")
+	}
+	if err := repo.Save(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(c.Changes()) != 0 || c.Version() != 2 {
+		t.Fatal("expected changes to be saved")
+	}
+	loaded, err := repo.Load(ctx, "c1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if loaded.count != 2 || loaded.Version() != 2 {
+		t.Errorf("expected rehydrated count 2 at version 2, got %d at %d", loaded.count, loaded.Version())
+	}
+	if err := loaded.Increment(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := loaded.Increment(); err == nil {
+		t.Error("expected the aggregate to reject the command")
+	}
+}
+
+func TestRepositoryConflict(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	repo := NewRepository(dispatcher, newCounter)
+	ctx := context.Background()
+	first, _ := repo.Load(ctx, "c1")
+	second, _ := repo.Load(ctx, "c1")
+	first.Increment()
+	second.Increment()
+	if err := repo.Save(context.Background(), first); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := repo.Save(context.Background(), second); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "c1"); version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+}
+
+func TestRepositorySaveRetried(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	repo := NewRepository(dispatcher, newCounter)
+	ctx := context.Background()
+	incremented := func(c *counter) Event {
+		return &identifiedEvent{testEvent: testEvent{ID: c.ID(), Kind: "Incremented"}, UID: "once"}
+	}
+	c, _ := repo.Load(ctx, "c1")
+	Raise(c, incremented(c))
+	if err := repo.Save(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// A retried command raises the same event again, which is already part of the loaded aggregate
+	c, _ = repo.Load(ctx, "c1")
+	Raise(c, incremented(c))
+	if err := repo.Save(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if c.Version() != 1 {
+		t.Errorf("expected version 1, got %d", c.Version())
+	}
+	if err := c.Increment(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := repo.Save(context.Background(), c); err != nil {
+		t.Errorf("unexpected error saving again: %s", err.Error())
+	}
+}
+
+func TestRaiseOtherEntity(t *testing.T) {
+	c := &counter{AggregateRoot: AggregateRoot{id: "c1"}}
+	if err := Raise(c, &testEvent{ID: "c2", Kind: "Incremented"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected invalid event, got %v", err)
+	}
+	if c.count != 0 || len(c.Changes()) != 0 {
+		t.Error("expected the event not to be applied")
+	}
+}
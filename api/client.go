@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/api/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Client is an eventstore.EventStore backed by a remote EventStore gRPC service. Errors returned by the
+// service wrap the same sentinel errors as the local store, e.g., eventstore.ErrVersionConflict.
+type Client struct {
+	c pb.EventStoreClient
+}
+
+// NewClient creates a Client using conn, which the caller remains responsible for closing.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{c: pb.NewEventStoreClient(conn)}
+}
+
+// Append appends events to an entity stream, provided it is at expectedVersion, or eventstore.AnyVersion.
+// Like the local store, it returns the persisted envelopes along with the error of a failed reducer.
+func (c *Client) Append(ctx context.Context, entityID string, expectedVersion int, events ...eventstore.Event) ([]*eventstore.Envelope, error) {
+	req := &pb.AppendRequest{
+		EntityId:        entityID,
+		ExpectedVersion: int64(expectedVersion),
+		Events:          make([]*pb.Event, len(events)),
+	}
+	for i, event := range events {
+		req.Events[i] = eventToPb(event)
+	}
+	reply, err := c.c.Append(ctx, req)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	envs := envelopesFromPb(reply.GetEnvelopes())
+	if msg := reply.GetReducerError(); msg != "" {
+		return envs, errors.New(msg)
+	}
+	return envs, nil
+}
+
+// Version returns the number of events stored for an entity.
+func (c *Client) Version(ctx context.Context, entityID string) (int, error) {
+	reply, err := c.c.Version(ctx, &pb.VersionRequest{EntityId: entityID})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	return int(reply.GetVersion()), nil
+}
+
+// ReadStream iterates over the events of an entity stamped after a position, in order, receiving them
+// from the service as they are read.
+func (c *Client) ReadStream(ctx context.Context, entityID string, after eventstore.Timestamp) eventstore.EnvelopeIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.c.ReadStream(ctx, &pb.ReadStreamRequest{EntityId: entityID, After: timestampToPb(after)})
+	if err != nil {
+		cancel()
+		return &envelopeIterator{err: fromStatus(err), done: true}
+	}
+	return &envelopeIterator{recv: stream.Recv, cancel: cancel}
+}
+
+// Find iterates over the events matching criteria, receiving them from the service as they are read.
+func (c *Client) Find(ctx context.Context, criteria eventstore.Criteria) eventstore.EnvelopeIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.c.Query(ctx, criteriaToPb(criteria))
+	if err != nil {
+		cancel()
+		return &envelopeIterator{err: fromStatus(err), done: true}
+	}
+	return &envelopeIterator{recv: stream.Recv, cancel: cancel}
+}
+
+// envelopeIterator is the eventstore.EnvelopeIterator of a ReadStream or Query call.
+type envelopeIterator struct {
+	recv   func() (*pb.Envelope, error)
+	cancel context.CancelFunc
+	env    *eventstore.Envelope
+	err    error
+	done   bool
+}
+
+// Next receives the next event, and reports whether there is one to read.
+func (it *envelopeIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	env, err := it.recv()
+	if err != nil {
+		if err != io.EOF {
+			it.err = fromStatus(err)
+		}
+		it.Close()
+		return false
+	}
+	it.env = envelopeFromPb(env)
+	return true
+}
+
+// Event returns the current event.
+func (it *envelopeIterator) Event() *eventstore.Envelope {
+	return it.env
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *envelopeIterator) Err() error {
+	return it.err
+}
+
+// Close cancels the call. It is safe to call more than once.
+func (it *envelopeIterator) Close() error {
+	it.done = true
+	if it.cancel != nil {
+		it.cancel()
+	}
+	return nil
+}
+
+// Watch streams the events matching filter stamped after from, and then live events, until ctx is done
+// or the stream is closed.
+func (c *Client) Watch(ctx context.Context, from eventstore.Timestamp, filter eventstore.SubscriptionFilter) (eventstore.EventStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.c.Subscribe(ctx, &pb.SubscribeRequest{
+		From:     timestampToPb(from),
+		Type:     filter.Type,
+		EntityId: filter.EntityID,
+	})
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	s := &eventStream{
+		ch:     make(chan *eventstore.Envelope),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go s.run(ctx, stream)
+	return s, nil
+}
+
+// eventStream is the eventstore.EventStream of a Subscribe call.
+type eventStream struct {
+	ch     chan *eventstore.Envelope
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func (s *eventStream) run(ctx context.Context, stream pb.EventStore_SubscribeClient) {
+	defer func() {
+		close(s.ch)
+		close(s.done)
+	}()
+	for {
+		env, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				s.err = fromStatus(err)
+			}
+			return
+		}
+		select {
+		case s.ch <- envelopeFromPb(env):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Channel returns the channel that receives events. It is closed when the stream ends.
+func (s *eventStream) Channel() <-chan *eventstore.Envelope {
+	return s.ch
+}
+
+// Err returns the error, if any, that ended the stream.
+func (s *eventStream) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close ends the stream and waits for its channel to be closed.
+func (s *eventStream) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// remoteError is an error returned by the service, wrapping the matching local sentinel error.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+// fromStatus converts a gRPC status error back to an event store error.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, c := range errorCodes {
+		if st.Code() == c.code && (c.code != codes.InvalidArgument || strings.HasPrefix(st.Message(), c.err.Error())) {
+			return &remoteError{msg: st.Message(), err: c.err}
+		}
+	}
+	return errors.New(st.Message())
+}
+
+// Sanity check
+var _ eventstore.EventStore = (*Client)(nil)
+var _ eventstore.EventStream = (*eventStream)(nil)
+var _ eventstore.EnvelopeIterator = (*envelopeIterator)(nil)
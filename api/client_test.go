@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/api/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// setup serves a new Dispatcher over an in-memory connection, and returns a client to it.
+func setup(t *testing.T) (*eventstore.Dispatcher, *Client) {
+	dispatcher := eventstore.NewDispatcher(eventstore.NewTxMapDatastore())
+	return dispatcher, serve(t, dispatcher)
+}
+
+// serve serves store over an in-memory connection, and returns a client to it.
+func serve(t *testing.T, store eventstore.EventStore) *Client {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterEventStoreServer(server, NewServer(store))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewClient(conn)
+}
+
+// readAll reads the events of it until it is exhausted.
+func readAll(it eventstore.EnvelopeIterator) ([]*eventstore.Envelope, error) {
+	defer it.Close()
+	var envs []*eventstore.Envelope
+	for it.Next() {
+		envs = append(envs, it.Event())
+	}
+	return envs, it.Err()
+}
+
+func newEvent(entityID, typ string, body string) eventstore.Event {
+	return eventstore.NewEvent(entityID, typ, nil, []byte(body), map[string]string{"source": "test"})
+}
+
+func TestAppend(t *testing.T) {
+	dispatcher, client := setup(t)
+	envs, err := client.Append(context.Background(), "a", 0, newEvent("a", "Created", "1"), newEvent("a", "Updated", "2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(envs) != 2 || !envs[0].Stamp.Less(envs[1].Stamp) {
+		t.Fatal("expected events stamped in order")
+	}
+	if version, err := client.Version(context.Background(), "a"); err != nil || version != 2 {
+		t.Errorf("expected version 2, got %d (%v)", version, err)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 2 {
+		t.Errorf("expected events stored locally, got version %d", version)
+	}
+	if _, err := client.Append(context.Background(), "a", 0, newEvent("a", "Updated", "3")); !errors.Is(err, eventstore.ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+	if _, err := client.Append(context.Background(), "a", eventstore.AnyVersion, newEvent("b", "Updated", "3")); !errors.Is(err, eventstore.ErrInvalidEvent) {
+		t.Errorf("expected invalid event, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Append(ctx, "a", eventstore.AnyVersion, newEvent("a", "Updated", "3")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 2 {
+		t.Errorf("expected nothing appended, got version %d", version)
+	}
+}
+
+type failingReducer struct{}
+
+func (failingReducer) Reduce(eventstore.Event) error {
+	return errors.New("reducer failed")
+}
+
+func TestAppendReducerError(t *testing.T) {
+	dispatcher, client := setup(t)
+	dispatcher.Register(failingReducer{})
+	envs, err := client.Append(context.Background(), "a", 0, newEvent("a", "Created", "1"))
+	if err == nil || err.Error() != "reducer failed" {
+		t.Fatalf("expected the reducer's error, got %v", err)
+	}
+	// The events are persisted regardless, and reported as such
+	if len(envs) != 1 || envs[0].Event.EntityID() != "a" {
+		t.Fatalf("expected the persisted envelope, got %v", envs)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+}
+
+func TestReadLargeStream(t *testing.T) {
+	_, client := setup(t)
+	// More than gRPC's default maximum message size in total
+	body := strings.Repeat("x", 64<<10)
+	for i := 0; i < 80; i++ {
+		if _, err := client.Append(context.Background(), "a", eventstore.AnyVersion, newEvent("a", "Updated", body)); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	ctx := context.Background()
+	stream, err := readAll(client.ReadStream(ctx, "a", eventstore.Timestamp{}))
+	if err != nil || len(stream) != 80 {
+		t.Fatalf("expected 80 events, got %d (%v)", len(stream), err)
+	}
+	found, err := readAll(client.Find(ctx, eventstore.Criteria{Type: "Updated"}))
+	if err != nil || len(found) != 80 {
+		t.Errorf("expected 80 events, got %d (%v)", len(found), err)
+	}
+}
+
+// endlessStore is an EventStore whose streams never end, signaling when they are closed.
+type endlessStore struct {
+	eventstore.EventStore
+	closed chan struct{}
+}
+
+func (s *endlessStore) ReadStream(ctx context.Context, entityID string, after eventstore.Timestamp) eventstore.EnvelopeIterator {
+	return &endlessIterator{ctx: ctx, entityID: entityID, closed: s.closed}
+}
+
+type endlessIterator struct {
+	ctx      context.Context
+	entityID string
+	n        int64
+	err      error
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (it *endlessIterator) Next() bool {
+	if it.err = it.ctx.Err(); it.err != nil {
+		return false
+	}
+	it.n++
+	return true
+}
+
+func (it *endlessIterator) Event() *eventstore.Envelope {
+	return &eventstore.Envelope{
+		Stamp: eventstore.Timestamp{Wall: it.n},
+		Event: eventstore.NewEvent(it.entityID, "Tick", nil, nil, nil),
+	}
+}
+
+func (it *endlessIterator) Err() error {
+	return it.err
+}
+
+func (it *endlessIterator) Close() error {
+	it.once.Do(func() { close(it.closed) })
+	return nil
+}
+
+func TestReadStreamEndless(t *testing.T) {
+	store := &endlessStore{closed: make(chan struct{})}
+	client := serve(t, store)
+	// Events are sent as they are read, and reading stops once the client goes away
+	it := client.ReadStream(context.Background(), "a", eventstore.Timestamp{})
+	for i := int64(1); i <= 3; i++ {
+		if !it.Next() || it.Event().Stamp.Wall != i {
+			t.Fatalf("expected event %d, got error %v", i, it.Err())
+		}
+	}
+	it.Close()
+	select {
+	case <-store.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the server to stop reading")
+	}
+}
+
+func TestRead(t *testing.T) {
+	_, client := setup(t)
+	for i, id := range []string{"a", "b", "a", "b"} {
+		if _, err := client.Append(context.Background(), id, eventstore.AnyVersion, newEvent(id, []string{"Created", "Updated"}[i/2], id)); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	ctx := context.Background()
+	stream, err := readAll(client.ReadStream(ctx, "a", eventstore.Timestamp{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(stream) != 2 || stream[1].Event.Type() != "Updated" || string(stream[1].Event.Body()) != "a" {
+		t.Fatalf("unexpected stream %v", stream)
+	}
+	if eventstore.MetadataValue(stream[0].Event, "source") != "test" {
+		t.Error("expected metadata to be preserved")
+	}
+	rest, err := readAll(client.ReadStream(ctx, "a", stream[0].Stamp))
+	if err != nil || len(rest) != 1 || rest[0].Stamp != stream[1].Stamp {
+		t.Errorf("expected the last event after the first, got %v (%v)", rest, err)
+	}
+	found, err := readAll(client.Find(ctx, eventstore.Criteria{Type: "Updated", Reverse: true}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(found) != 2 || found[0].Event.EntityID() != "b" {
+		t.Errorf("expected updates newest first, got %v", found)
+	}
+	found, err = readAll(client.Find(ctx, eventstore.Criteria{From: time.Unix(0, 0), To: stream[1].Stamp.Time()}))
+	if err != nil || len(found) != 2 {
+		t.Errorf("expected 2 events before the second of `a`, got %d (%v)", len(found), err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	_, client := setup(t)
+	first, err := client.Append(context.Background(), "a", 0, newEvent("a", "Created", "1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := client.Append(context.Background(), "a", 1, newEvent("a", "Updated", "2")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, first[0].Stamp, eventstore.SubscriptionFilter{EntityID: "a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := client.Append(context.Background(), "b", 0, newEvent("b", "Created", "3")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := client.Append(context.Background(), "a", 2, newEvent("a", "Deleted", "4")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for _, body := range []string{"2", "4"} {
+		select {
+		case env := <-stream.Channel():
+			if string(env.Event.Body()) != body {
+				t.Errorf("expected event %s, got %s", body, env.Event.Body())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("watch timed out waiting for event %s", body)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, ok := <-stream.Channel(); ok {
+		t.Error("expected channel to be closed")
+	}
+	if err := stream.Err(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"time"
+
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/api/pb"
+)
+
+func timestampToPb(ts eventstore.Timestamp) *pb.Timestamp {
+	if ts.IsZero() {
+		return nil
+	}
+	return &pb.Timestamp{Wall: ts.Wall, Logical: ts.Logical, Node: ts.Node}
+}
+
+func timestampFromPb(ts *pb.Timestamp) eventstore.Timestamp {
+	if ts == nil {
+		return eventstore.Timestamp{}
+	}
+	return eventstore.Timestamp{Wall: ts.Wall, Logical: ts.Logical, Node: ts.Node}
+}
+
+func eventToPb(event eventstore.Event) *pb.Event {
+	e := &pb.Event{
+		EntityId: event.EntityID(),
+		Type:     event.Type(),
+		Time:     event.Time(),
+		Body:     event.Body(),
+	}
+	if m, ok := event.(eventstore.EventMetadata); ok {
+		e.Metadata = m.Metadata()
+	}
+	return e
+}
+
+func eventFromPb(e *pb.Event) eventstore.Event {
+	return eventstore.NewEvent(e.GetEntityId(), e.GetType(), e.GetTime(), e.GetBody(), e.GetMetadata())
+}
+
+func eventsFromPb(es []*pb.Event) []eventstore.Event {
+	events := make([]eventstore.Event, len(es))
+	for i, e := range es {
+		events[i] = eventFromPb(e)
+	}
+	return events
+}
+
+func envelopeToPb(env *eventstore.Envelope) *pb.Envelope {
+	return &pb.Envelope{Stamp: timestampToPb(env.Stamp), Event: eventToPb(env.Event)}
+}
+
+func envelopeFromPb(env *pb.Envelope) *eventstore.Envelope {
+	return &eventstore.Envelope{Stamp: timestampFromPb(env.GetStamp()), Event: eventFromPb(env.GetEvent())}
+}
+
+func envelopesToPb(envs []*eventstore.Envelope) []*pb.Envelope {
+	es := make([]*pb.Envelope, len(envs))
+	for i, env := range envs {
+		es[i] = envelopeToPb(env)
+	}
+	return es
+}
+
+func envelopesFromPb(es []*pb.Envelope) []*eventstore.Envelope {
+	envs := make([]*eventstore.Envelope, len(es))
+	for i, env := range es {
+		envs[i] = envelopeFromPb(env)
+	}
+	return envs
+}
+
+func criteriaToPb(c eventstore.Criteria) *pb.QueryRequest {
+	req := &pb.QueryRequest{
+		Type:     c.Type,
+		EntityId: c.EntityID,
+		After:    timestampToPb(c.After),
+		Limit:    int32(c.Limit),
+		Reverse:  c.Reverse,
+	}
+	if !c.To.IsZero() {
+		req.From, req.To = c.From.UnixNano(), c.To.UnixNano()
+	}
+	return req
+}
+
+func criteriaFromPb(req *pb.QueryRequest) eventstore.Criteria {
+	c := eventstore.Criteria{
+		Type:     req.GetType(),
+		EntityID: req.GetEntityId(),
+		After:    timestampFromPb(req.GetAfter()),
+		Limit:    int(req.GetLimit()),
+		Reverse:  req.GetReverse(),
+	}
+	if req.GetTo() != 0 {
+		c.From, c.To = time.Unix(0, req.GetFrom()), time.Unix(0, req.GetTo())
+	}
+	return c
+}
@@ -0,0 +1,477 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/broadcast"
+)
+
+// HTTP API
+//
+// Handler serves an event store as JSON over HTTP, for browser tools and scripts:
+//
+//     POST /streams/<entity-id>            append events, see appendRequest and appendReply, with bodies
+//                                          up to the size set with WithMaxBodySize
+//     GET  /streams/<entity-id>?after=     read an entity stream
+//     GET  /streams/<entity-id>/version    get the version of an entity stream
+//     GET  /events?type=&entity_id=&from=&to=&after=&limit=&reverse=
+//                                          query events, with from and to in RFC 3339
+//     GET  /events/live?type=&entity_id=&after=
+//                                          stream events, as Server-Sent Events
+//     GET  /views/<name>/changes?key=&prefix=
+//                                          stream the changes of a ViewModel, as Server-Sent Events
+//     GET  /views/ws                       stream the changes of ViewModels, over a WebSocket (see
+//                                          websocket.go)
+//
+// Entity IDs are path-escaped, so that those containing '/', such as saga streams, are written as, e.g.,
+// /streams/saga%2Forder%2F1. Positions, such as after, are timestamps formatted as by eventstore.Timestamp.String. Live events are
+// sent with their stamp as ID, so that a reconnecting EventSource resumes after the last event it
+// received, through the Last-Event-ID header. View changes have no position, and are only streamed live.
+
+// errInvalidParameter is returned for malformed query parameters.
+var errInvalidParameter = errors.New("invalid parameter")
+
+// DefaultMaxBodySize is the largest append request body accepted by default, in bytes.
+const DefaultMaxBodySize = 4 << 20
+
+// HandlerOption configures a Handler.
+type HandlerOption func(*Handler)
+
+// WithMaxBodySize sets the largest append request body accepted, in bytes. Larger bodies are rejected
+// with status 413. Defaults to DefaultMaxBodySize.
+func WithMaxBodySize(n int64) HandlerOption {
+	return func(h *Handler) {
+		h.maxBodySize = n
+	}
+}
+
+// WithView exposes the changes of a ViewModel under name.
+func WithView(name string, view eventstore.ViewModel) HandlerOption {
+	return func(h *Handler) {
+		h.views[name] = view
+	}
+}
+
+// Handler is an http.Handler serving an eventstore.EventStore as JSON.
+type Handler struct {
+	store        eventstore.EventStore
+	views        map[string]eventstore.ViewModel
+	mux          *http.ServeMux
+	upgrader     websocket.Upgrader
+	wsBuffer     int
+	wsReadLimit  int64
+	writeTimeout time.Duration
+	maxBodySize  int64
+}
+
+// NewHandler creates a Handler serving store.
+func NewHandler(store eventstore.EventStore, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		store:        store,
+		views:        make(map[string]eventstore.ViewModel),
+		mux:          http.NewServeMux(),
+		wsBuffer:     DefaultWebSocketBuffer,
+		wsReadLimit:  DefaultWebSocketReadLimit,
+		writeTimeout: DefaultWriteTimeout,
+		maxBodySize:  DefaultMaxBodySize,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("/streams/", h.serveStream)
+	h.mux.HandleFunc("/events", h.serveEvents)
+	h.mux.HandleFunc("/events/live", h.serveLive)
+	h.mux.HandleFunc("/views/", h.serveView)
+	h.mux.HandleFunc("/views/ws", h.serveWebSocket)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// jsonEvent is the JSON form of an event. Time and Body are base64 encoded.
+type jsonEvent struct {
+	EntityID string            `json:"entity_id"`
+	Type     string            `json:"type"`
+	Time     []byte            `json:"time,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// jsonEnvelope is the JSON form of an envelope.
+type jsonEnvelope struct {
+	Stamp string    `json:"stamp"`
+	Event jsonEvent `json:"event"`
+}
+
+// appendRequest is the body of an append. Events default to the entity of the stream, and
+// ExpectedVersion to any version.
+type appendRequest struct {
+	ExpectedVersion *int        `json:"expected_version,omitempty"`
+	Events          []jsonEvent `json:"events"`
+}
+
+type envelopesReply struct {
+	Envelopes []jsonEnvelope `json:"envelopes"`
+}
+
+// appendReply is the reply of an append. ReducerError is set if the events were persisted but a reducer
+// failed, so the append must not be retried.
+type appendReply struct {
+	envelopesReply
+	ReducerError string `json:"reducer_error,omitempty"`
+}
+
+type versionReply struct {
+	Version int `json:"version"`
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) serveStream(w http.ResponseWriter, r *http.Request) {
+	// Split the escaped path, so that escaped slashes stay part of the entity ID
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/streams/"), "/")
+	version := len(segments) == 2 && segments[1] == "version"
+	entityID, err := url.PathUnescape(segments[0])
+	if err != nil || entityID == "" || len(segments) > 2 || (len(segments) == 2 && !version) {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case version && r.Method == http.MethodGet:
+		v, err := h.store.Version(r.Context(), entityID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, versionReply{Version: v})
+	case !version && r.Method == http.MethodGet:
+		after, err := parseAfter(r.URL.Query().Get("after"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeEnvelopes(w, h.store.ReadStream(r.Context(), entityID, after))
+	case !version && r.Method == http.MethodPost:
+		h.append(w, r, entityID)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, errorReply{Error: "method not allowed"})
+	}
+}
+
+func (h *Handler) append(w http.ResponseWriter, r *http.Request, entityID string) {
+	var req appendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBodySize)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorReply{Error: err.Error()})
+			return
+		}
+		writeError(w, fmt.Errorf("%w: %s", eventstore.ErrInvalidEvent, err))
+		return
+	}
+	expected := eventstore.AnyVersion
+	if req.ExpectedVersion != nil {
+		expected = *req.ExpectedVersion
+	}
+	events := make([]eventstore.Event, len(req.Events))
+	for i, e := range req.Events {
+		if e.EntityID == "" {
+			e.EntityID = entityID
+		}
+		events[i] = eventstore.NewEvent(e.EntityID, e.Type, e.Time, e.Body, e.Metadata)
+	}
+	envs, err := h.store.Append(r.Context(), entityID, expected, events...)
+	if envs == nil && err != nil {
+		writeError(w, err)
+		return
+	}
+	reply := appendReply{envelopesReply: envelopesToJSON(envs)}
+	if err != nil {
+		reply.ReducerError = err.Error()
+	}
+	writeJSON(w, http.StatusCreated, reply)
+}
+
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorReply{Error: "method not allowed"})
+		return
+	}
+	criteria, err := parseCriteria(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeEnvelopes(w, h.store.Find(r.Context(), criteria))
+}
+
+func (h *Handler) serveLive(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	position := q.Get("after")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		position = id
+	}
+	after, err := parseAfter(position)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filter := eventstore.SubscriptionFilter{Type: q.Get("type"), EntityID: q.Get("entity_id")}
+	stream, err := h.store.Watch(r.Context(), after, filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer stream.Close()
+	sse, ok := newEventWriter(w)
+	if !ok {
+		return
+	}
+	for env := range stream.Channel() {
+		if err := sse.send(env.Stamp.String(), env.Event.Type(), envelopeToJSON(env)); err != nil {
+			return
+		}
+	}
+}
+
+func (h *Handler) serveView(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/views/"), "/changes")
+	view, ok := h.views[name]
+	if !ok || r.URL.Path != "/views/"+name+"/changes" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	l := listenView(view, q.Get("key"), q.Get("prefix"))
+	defer l.Discard()
+	sse, ok := newEventWriter(w)
+	if !ok {
+		return
+	}
+	for {
+		select {
+		case change, ok := <-l.Channel():
+			if !ok {
+				return
+			}
+			if err := sse.send("", "change", change); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// listenView returns a listener receiving the changes of view about key, or about keys starting with
+// prefix. The view's broadcaster filters the changes, so that other changes are neither queued nor
+// dropped for the listener.
+func listenView(view eventstore.ViewModel, key, prefix string) *broadcast.Listener[interface{}] {
+	switch {
+	case key == "" && prefix == "":
+		return view.Listen()
+	case prefix == "":
+		return view.ListenKey(key)
+	case key == "":
+		return view.ListenPrefix(prefix)
+	default:
+		return view.ListenFiltered(matchTopic(key, prefix))
+	}
+}
+
+// matchTopic returns a predicate selecting the changes about key, or about keys starting with prefix.
+// Changes must implement broadcast.Topic to be selected by either.
+func matchTopic(key, prefix string) func(interface{}) bool {
+	return func(change interface{}) bool {
+		if key == "" && prefix == "" {
+			return true
+		}
+		t, ok := change.(broadcast.Topic)
+		if !ok {
+			return false
+		}
+		return (key == "" || t.Topic() == key) && strings.HasPrefix(t.Topic(), prefix)
+	}
+}
+
+// eventWriter writes Server-Sent Events.
+type eventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newEventWriter starts an event stream response, or reports an error if w cannot stream.
+func newEventWriter(w http.ResponseWriter) (*eventWriter, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorReply{Error: "streaming not supported"})
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventWriter{w: w, flusher: flusher}, true
+}
+
+// lineBreaks replaces the line endings of Server-Sent Events, so that values cannot add fields.
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// send writes an event of the given ID, if any, and type, with v as JSON data.
+func (e *eventWriter) send(id, typ string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", lineBreaks.Replace(typ), data)
+	if _, err := e.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func parseAfter(s string) (eventstore.Timestamp, error) {
+	if s == "" {
+		return eventstore.Timestamp{}, nil
+	}
+	return eventstore.ParseTimestamp(s)
+}
+
+func parseCriteria(r *http.Request) (eventstore.Criteria, error) {
+	q := r.URL.Query()
+	c := eventstore.Criteria{Type: q.Get("type"), EntityID: q.Get("entity_id")}
+	var err error
+	if c.After, err = parseAfter(q.Get("after")); err != nil {
+		return c, err
+	}
+	if s := q.Get("limit"); s != "" {
+		if c.Limit, err = strconv.Atoi(s); err != nil || c.Limit < 0 {
+			return c, fmt.Errorf("%w: `%s`", errInvalidParameter, "limit")
+		}
+	}
+	if s := q.Get("reverse"); s != "" {
+		if c.Reverse, err = strconv.ParseBool(s); err != nil {
+			return c, fmt.Errorf("%w: `%s`", errInvalidParameter, "reverse")
+		}
+	}
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" && to == "" {
+		return c, nil
+	}
+	if to == "" {
+		c.To = time.Unix(0, math.MaxInt64)
+	} else if c.To, err = time.Parse(time.RFC3339Nano, to); err != nil {
+		return c, fmt.Errorf("%w: `%s`", errInvalidParameter, "to")
+	}
+	if from != "" {
+		if c.From, err = time.Parse(time.RFC3339Nano, from); err != nil {
+			return c, fmt.Errorf("%w: `%s`", errInvalidParameter, "from")
+		}
+	}
+	return c, nil
+}
+
+// httpStatuses maps the errors of the event store to HTTP statuses.
+var httpStatuses = []struct {
+	err    error
+	status int
+}{
+	{eventstore.ErrVersionConflict, http.StatusConflict},
+	{eventstore.ErrInvalidEvent, http.StatusBadRequest},
+	{eventstore.ErrInvalidKey, http.StatusBadRequest},
+	{eventstore.ErrInvalidTimestamp, http.StatusBadRequest},
+	{errInvalidParameter, http.StatusBadRequest},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout},
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	for _, s := range httpStatuses {
+		if errors.Is(err, s.err) {
+			status = s.status
+			break
+		}
+	}
+	writeJSON(w, status, errorReply{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeEnvelopes writes the events of it as an envelopesReply, encoding them as they are read, so that long
+// replies do not have to fit in memory. An error before the first event is written as an error reply, and
+// a later one aborts the response, so that clients see it truncated rather than complete.
+func writeEnvelopes(w http.ResponseWriter, it eventstore.EnvelopeIterator) {
+	defer it.Close()
+	more := it.Next()
+	if err := it.Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"envelopes":[`)
+	for first := true; more; more, first = it.Next(), false {
+		b, err := json.Marshal(envelopeToJSON(it.Event()))
+		if err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if !first {
+			io.WriteString(w, ",")
+		}
+		w.Write(b)
+	}
+	if it.Err() != nil {
+		panic(http.ErrAbortHandler)
+	}
+	io.WriteString(w, "]}\n")
+}
+
+func envelopeToJSON(env *eventstore.Envelope) jsonEnvelope {
+	e := jsonEvent{
+		EntityID: env.Event.EntityID(),
+		Type:     env.Event.Type(),
+		Time:     env.Event.Time(),
+		Body:     env.Event.Body(),
+	}
+	if m, ok := env.Event.(eventstore.EventMetadata); ok {
+		e.Metadata = m.Metadata()
+	}
+	return jsonEnvelope{Stamp: env.Stamp.String(), Event: e}
+}
+
+func envelopesToJSON(envs []*eventstore.Envelope) envelopesReply {
+	reply := envelopesReply{Envelopes: make([]jsonEnvelope, len(envs))}
+	for i, env := range envs {
+		reply.Envelopes[i] = envelopeToJSON(env)
+	}
+	return reply
+}
+
+// Sanity check
+var _ http.Handler = (*Handler)(nil)
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	eventstore "github.com/textileio/go-eventstore"
+)
+
+// setupHTTP serves a new Dispatcher, and a view named `counts`, over HTTP.
+func setupHTTP(t *testing.T) (*eventstore.Dispatcher, *eventstore.MemoryModel, *httptest.Server) {
+	dispatcher := eventstore.NewDispatcher(eventstore.NewTxMapDatastore())
+	view := eventstore.NewTypedMemoryModel[interface{}](10)
+	server := httptest.NewServer(NewHandler(dispatcher, WithView("counts", view)))
+	t.Cleanup(server.Close)
+	return dispatcher, view, server
+}
+
+// do sends a request, checks the response status, and decodes its JSON body into reply, if not nil.
+func do(t *testing.T, method, url, body string, status int, reply interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d", method, url, status, resp.StatusCode)
+	}
+	if reply != nil {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+}
+
+// sseEvent is a parsed Server-Sent Event.
+type sseEvent struct {
+	id, typ, data string
+}
+
+// openEvents opens an event stream, with the given Last-Event-ID if not empty, and returns its events.
+func openEvents(t *testing.T, url, lastEventID string) <-chan sseEvent {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type `%s`", ct)
+	}
+	ch := make(chan sseEvent)
+	go func() {
+		defer resp.Body.Close()
+		defer close(ch)
+		scanner := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.typ = value
+			case "data":
+				e.data = value
+			case "":
+				ch <- e
+				e = sseEvent{}
+			}
+		}
+	}()
+	return ch
+}
+
+func next(t *testing.T, ch <-chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-ch:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("event stream timed out")
+	}
+	return sseEvent{}
+}
+
+func TestHTTPStreams(t *testing.T) {
+	_, _, server := setupHTTP(t)
+	var appended envelopesReply
+	do(t, http.MethodPost, server.URL+"/streams/a", `{"expected_version": 0, "events": [
+		{"type": "Created", "body": "MQ==", "metadata": {"source": "test"}},
+		{"type": "Updated", "body": "Mg=="}]}`, http.StatusCreated, &appended)
+	if len(appended.Envelopes) != 2 || appended.Envelopes[0].Event.EntityID != "a" {
+		t.Fatalf("unexpected reply %v", appended)
+	}
+	do(t, http.MethodPost, server.URL+"/streams/a", `{"expected_version": 0, "events": [{"type": "Updated"}]}`,
+		http.StatusConflict, nil)
+	do(t, http.MethodPost, server.URL+"/streams/a", `{"events": [{"entity_id": "b", "type": "Updated"}]}`,
+		http.StatusBadRequest, nil)
+	do(t, http.MethodPost, server.URL+"/streams/b", `{"events": [{"type": "Created"}]}`, http.StatusCreated, nil)
+
+	var version versionReply
+	do(t, http.MethodGet, server.URL+"/streams/a/version", "", http.StatusOK, &version)
+	if version.Version != 2 {
+		t.Errorf("expected version 2, got %d", version.Version)
+	}
+	var stream envelopesReply
+	do(t, http.MethodGet, server.URL+"/streams/a?after="+appended.Envelopes[0].Stamp, "", http.StatusOK, &stream)
+	if len(stream.Envelopes) != 1 || string(stream.Envelopes[0].Event.Body) != "2" {
+		t.Errorf("expected the second event, got %v", stream)
+	}
+	do(t, http.MethodGet, server.URL+"/streams/a?after=bad", "", http.StatusBadRequest, nil)
+	do(t, http.MethodDelete, server.URL+"/streams/a", "", http.StatusMethodNotAllowed, nil)
+}
+
+func TestHTTPEscapedEntityID(t *testing.T) {
+	_, _, server := setupHTTP(t)
+	var appended envelopesReply
+	do(t, http.MethodPost, server.URL+"/streams/saga%2Forder%2F1", `{"events": [{"type": "Created"}]}`,
+		http.StatusCreated, &appended)
+	if len(appended.Envelopes) != 1 || appended.Envelopes[0].Event.EntityID != "saga/order/1" {
+		t.Fatalf("unexpected reply %v", appended)
+	}
+	var version versionReply
+	do(t, http.MethodGet, server.URL+"/streams/saga%2Forder%2F1/version", "", http.StatusOK, &version)
+	if version.Version != 1 {
+		t.Errorf("expected version 1, got %d", version.Version)
+	}
+	var stream envelopesReply
+	do(t, http.MethodGet, server.URL+"/streams/saga%2Forder%2F1", "", http.StatusOK, &stream)
+	if len(stream.Envelopes) != 1 {
+		t.Errorf("expected 1 event, got %d", len(stream.Envelopes))
+	}
+	// Unescaped slashes separate path segments
+	do(t, http.MethodGet, server.URL+"/streams/saga/order/1", "", http.StatusNotFound, nil)
+	do(t, http.MethodGet, server.URL+"/streams/a/versions", "", http.StatusNotFound, nil)
+}
+
+func TestHTTPAppendTooLarge(t *testing.T) {
+	dispatcher := eventstore.NewDispatcher(eventstore.NewTxMapDatastore())
+	server := httptest.NewServer(NewHandler(dispatcher, WithMaxBodySize(64)))
+	defer server.Close()
+	body := `{"events": [{"type": "Created", "body": "` + strings.Repeat("eA==", 32) + `"}]}`
+	do(t, http.MethodPost, server.URL+"/streams/a", body, http.StatusRequestEntityTooLarge, nil)
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 0 {
+		t.Errorf("expected nothing appended, got version %d", version)
+	}
+}
+
+func TestHTTPAppendReducerError(t *testing.T) {
+	dispatcher, _, server := setupHTTP(t)
+	dispatcher.Register(failingReducer{})
+	// The events are persisted regardless, so the append succeeds and reports the reducer's error
+	var reply appendReply
+	do(t, http.MethodPost, server.URL+"/streams/a", `{"expected_version": 0, "events": [{"type": "Created"}]}`,
+		http.StatusCreated, &reply)
+	if len(reply.Envelopes) != 1 || reply.ReducerError != "reducer failed" {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+}
+
+func TestEventWriterLineBreaks(t *testing.T) {
+	rec := httptest.NewRecorder()
+	e := &eventWriter{w: rec, flusher: rec}
+	if err := e.send("", "A\rid: 1\r\ndata: 2\nB", "x"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if body := rec.Body.String(); body != "event: A id: 1 data: 2 B\ndata: \"x\"\n\n" {
+		t.Errorf("unexpected event %q", body)
+	}
+}
+
+func TestHTTPEvents(t *testing.T) {
+	dispatcher, _, server := setupHTTP(t)
+	for i, id := range []string{"a", "b", "a", "b"} {
+		event := eventstore.NewEvent(id, []string{"Created", "Updated"}[i/2], nil, nil, nil)
+		if _, err := dispatcher.Append(context.Background(), id, eventstore.AnyVersion, event); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	var reply envelopesReply
+	do(t, http.MethodGet, server.URL+"/events?type=Updated&reverse=true&limit=1", "", http.StatusOK, &reply)
+	if len(reply.Envelopes) != 1 || reply.Envelopes[0].Event.EntityID != "b" {
+		t.Errorf("expected the last update, got %v", reply)
+	}
+	do(t, http.MethodGet, server.URL+"/events?entity_id=a&from="+time.Now().Add(-time.Hour).Format(time.RFC3339Nano),
+		"", http.StatusOK, &reply)
+	if len(reply.Envelopes) != 2 {
+		t.Errorf("expected 2 events, got %d", len(reply.Envelopes))
+	}
+	do(t, http.MethodGet, server.URL+"/events?to="+time.Now().Add(-time.Hour).Format(time.RFC3339Nano),
+		"", http.StatusOK, &reply)
+	if len(reply.Envelopes) != 0 {
+		t.Errorf("expected no events, got %d", len(reply.Envelopes))
+	}
+	do(t, http.MethodGet, server.URL+"/events?limit=-1", "", http.StatusBadRequest, nil)
+}
+
+func TestHTTPLiveEvents(t *testing.T) {
+	dispatcher, _, server := setupHTTP(t)
+	first, err := dispatcher.Append(context.Background(), "a", 0, eventstore.NewEvent("a", "Created", nil, []byte("1"), nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	events := openEvents(t, server.URL+"/events/live?entity_id=a", "")
+	e := next(t, events)
+	if e.id != first[0].Stamp.String() || e.typ != "Created" {
+		t.Errorf("unexpected event %v", e)
+	}
+	if _, err := dispatcher.Append(context.Background(), "b", 0, eventstore.NewEvent("b", "Created", nil, nil, nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := dispatcher.Append(context.Background(), "a", 1, eventstore.NewEvent("a", "Updated", nil, []byte("2"), nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	e = next(t, events)
+	var env jsonEnvelope
+	if err := json.Unmarshal([]byte(e.data), &env); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if e.typ != "Updated" || env.Stamp != e.id || string(env.Event.Body) != "2" {
+		t.Errorf("unexpected event %v", e)
+	}
+	// Reconnecting resumes after the last event received
+	resumed := openEvents(t, server.URL+"/events/live?entity_id=a", first[0].Stamp.String())
+	if e := next(t, resumed); e.typ != "Updated" {
+		t.Errorf("expected to resume at the update, got %v", e)
+	}
+}
+
+func TestHTTPViewChanges(t *testing.T) {
+	_, view, server := setupHTTP(t)
+	do(t, http.MethodGet, server.URL+"/views/missing/changes", "", http.StatusNotFound, nil)
+	changes := openEvents(t, server.URL+"/views/counts/changes?key=a", "")
+	// Wait for the listener to be registered before notifying
+	deadline := time.Now().Add(time.Second)
+	for {
+		if err := view.Notify(eventstore.Change{Key: "b", Value: 1}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if err := view.Notify(eventstore.Change{Key: "a", Value: 2}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		select {
+		case e := <-changes:
+			var change eventstore.Change
+			if err := json.Unmarshal([]byte(e.data), &change); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if e.typ != "change" || change.Key != "a" || change.Value != 2.0 {
+				t.Errorf("unexpected change %v", e)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("change stream timed out")
+		}
+	}
+}
+
+func TestListenView(t *testing.T) {
+	tests := []struct {
+		name        string
+		key, prefix string
+	}{
+		{"key", "a/1", ""},
+		{"prefix", "", "a/"},
+		{"key and prefix", "a/1", "a/"},
+	}
+	for _, test := range tests {
+		view := eventstore.NewTypedMemoryModel[interface{}](1)
+		l := listenView(view, test.key, test.prefix)
+		// Changes about other keys are filtered before they are queued, so they cannot fill the buffer
+		for i := 0; i < 3; i++ {
+			view.Notify(eventstore.Change{Key: "b/1", Value: i})
+		}
+		view.Notify(eventstore.Change{Key: "a/1", Value: 1})
+		if l.Dropped() != 0 {
+			t.Errorf("%s: expected nothing dropped, got %d", test.name, l.Dropped())
+		}
+		if change := <-l.Channel(); change.(eventstore.Change).Key != "a/1" {
+			t.Errorf("%s: unexpected change %v", test.name, change)
+		}
+		l.Discard()
+	}
+}
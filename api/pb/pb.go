@@ -0,0 +1,4 @@
+// Package pb holds the protocol buffer messages and gRPC service definitions of the event store API.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative eventstore.proto
@@ -0,0 +1,126 @@
+// Package api exposes an event store over gRPC, with a server wrapping any eventstore.EventStore, such
+// as a Dispatcher, and a client implementing eventstore.EventStore, so that remote stores can be used
+// like local ones. Handler also serves an event store as JSON over HTTP.
+//
+//     server := grpc.NewServer()
+//     pb.RegisterEventStoreServer(server, api.NewServer(dispatcher))
+//
+//     conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+//     store := api.NewClient(conn)
+//     envs, err := store.Append(ctx, "order-1", 0, placed)
+package api
+
+import (
+	"context"
+	"errors"
+
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/api/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the EventStore gRPC service on top of an eventstore.EventStore.
+type Server struct {
+	pb.UnimplementedEventStoreServer
+	store eventstore.EventStore
+}
+
+// NewServer creates a Server serving store.
+func NewServer(store eventstore.EventStore) *Server {
+	return &Server{store: store}
+}
+
+// Append appends events to an entity stream, provided it is at the expected version. If the events are
+// persisted but a reducer fails, the reply holds both the envelopes and the reducer's error.
+func (s *Server) Append(ctx context.Context, req *pb.AppendRequest) (*pb.AppendReply, error) {
+	envs, err := s.store.Append(ctx, req.GetEntityId(), int(req.GetExpectedVersion()), eventsFromPb(req.GetEvents())...)
+	if envs == nil && err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &pb.AppendReply{Envelopes: envelopesToPb(envs)}
+	if err != nil {
+		reply.ReducerError = err.Error()
+	}
+	return reply, nil
+}
+
+// Version returns the number of events stored for an entity.
+func (s *Server) Version(ctx context.Context, req *pb.VersionRequest) (*pb.VersionReply, error) {
+	version, err := s.store.Version(ctx, req.GetEntityId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.VersionReply{Version: int64(version)}, nil
+}
+
+// ReadStream streams the events of an entity, in order.
+func (s *Server) ReadStream(req *pb.ReadStreamRequest, stream pb.EventStore_ReadStreamServer) error {
+	return sendAll(s.store.ReadStream(stream.Context(), req.GetEntityId(), timestampFromPb(req.GetAfter())), stream.Send)
+}
+
+// Query streams the events matching criteria.
+func (s *Server) Query(req *pb.QueryRequest, stream pb.EventStore_QueryServer) error {
+	return sendAll(s.store.Find(stream.Context(), criteriaFromPb(req)), stream.Send)
+}
+
+// sendAll sends envelopes one message at a time, as they are read, so that long streams neither stay in
+// memory nor exceed message size limits. It stops when the stream's context is done, which also stops it.
+func sendAll(it eventstore.EnvelopeIterator, send func(*pb.Envelope) error) error {
+	defer it.Close()
+	for it.Next() {
+		if err := send(envelopeToPb(it.Event())); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// Subscribe streams the events stamped after a position, followed by live events, until the client
+// goes away.
+func (s *Server) Subscribe(req *pb.SubscribeRequest, stream pb.EventStore_SubscribeServer) error {
+	filter := eventstore.SubscriptionFilter{Type: req.GetType(), EntityID: req.GetEntityId()}
+	sub, err := s.store.Watch(stream.Context(), timestampFromPb(req.GetFrom()), filter)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer sub.Close()
+	for env := range sub.Channel() {
+		if err := stream.Send(envelopeToPb(env)); err != nil {
+			return err
+		}
+	}
+	if err := sub.Err(); err != nil {
+		return toStatus(err)
+	}
+	return stream.Context().Err()
+}
+
+// errorCodes maps the errors of the event store to gRPC status codes, which clients map back.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{eventstore.ErrVersionConflict, codes.Aborted},
+	// Errors sharing a code are told apart by message prefix, so longer messages come first
+	{eventstore.ErrInvalidKey, codes.InvalidArgument},
+	{eventstore.ErrInvalidEvent, codes.InvalidArgument},
+	{context.Canceled, codes.Canceled},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+}
+
+// toStatus converts an event store error to a gRPC status error.
+func toStatus(err error) error {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return status.Error(c.code, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Sanity check
+var _ pb.EventStoreServer = (*Server)(nil)
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	eventstore "github.com/textileio/go-eventstore"
+)
+
+// WebSocket gateway
+//
+// GET /views/ws upgrades to a WebSocket, on which clients subscribe to the views exposed with WithView,
+// optionally filtered by key or key prefix, by sending:
+//
+//     {"type": "subscribe", "view": "orders", "key": "order-1"}
+//     {"type": "unsubscribe", "view": "orders"}
+//
+// For each subscription, the gateway first sends the current state of the view, if it is a
+// StatefulViewModel, and then every change notification, as:
+//
+//     {"type": "state", "view": "orders", "changes": [{"Key": "order-1", "Value": ...}]}
+//     {"type": "change", "view": "orders", "change": {"Key": "order-1", "Value": ...}}
+//
+// Changes made while the state is read may be sent twice. Errors, such as unknown views, are sent as
+// {"type": "error", "view": ..., "error": ...}. A client sending a message larger than the limit set with
+// WithWebSocketReadLimit is disconnected.
+//
+// Messages to a client are queued up to the buffer set with WithWebSocketBuffer. A client that falls
+// behind, by filling its queue, by missing change notifications dropped by a view's broadcaster, or by
+// not accepting a message within the write timeout, is disconnected with close code 1013 (try again
+// later), as it could not be brought up to date without resending the whole state anyway. Clients
+// should reconnect and subscribe again. Views filter changes by key before queuing them, so changes
+// outside a client's subscriptions never make it fall behind.
+
+const (
+	// DefaultWebSocketBuffer is the number of messages queued for a WebSocket client by default.
+	DefaultWebSocketBuffer = 64
+	// DefaultWriteTimeout is the time a WebSocket client has to accept a message by default.
+	DefaultWriteTimeout = 10 * time.Second
+	// DefaultWebSocketReadLimit is the largest message accepted from a WebSocket client by default, in
+	// bytes.
+	DefaultWebSocketReadLimit = 4 << 10
+)
+
+// WithWebSocketBuffer sets the number of messages queued for each WebSocket client. Defaults to
+// DefaultWebSocketBuffer.
+func WithWebSocketBuffer(n int) HandlerOption {
+	return func(h *Handler) {
+		h.wsBuffer = n
+	}
+}
+
+// WithWriteTimeout sets the time a WebSocket client has to accept a message before being disconnected.
+// Defaults to DefaultWriteTimeout.
+func WithWriteTimeout(d time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.writeTimeout = d
+	}
+}
+
+// WithWebSocketReadLimit sets the largest message accepted from a WebSocket client, in bytes. Defaults to
+// DefaultWebSocketReadLimit.
+func WithWebSocketReadLimit(n int64) HandlerOption {
+	return func(h *Handler) {
+		h.wsReadLimit = n
+	}
+}
+
+// WithCheckOrigin sets the function deciding whether to accept WebSocket connections from the origin of
+// a request. Defaults to accepting requests without an Origin header, or from the same host.
+func WithCheckOrigin(check func(r *http.Request) bool) HandlerOption {
+	return func(h *Handler) {
+		h.upgrader.CheckOrigin = check
+	}
+}
+
+// wsRequest is a message from a WebSocket client.
+type wsRequest struct {
+	Type   string `json:"type"`
+	View   string `json:"view"`
+	Key    string `json:"key,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// wsMessage is a message to a WebSocket client.
+type wsMessage struct {
+	Type    string              `json:"type"`
+	View    string              `json:"view,omitempty"`
+	Changes []eventstore.Change `json:"changes,omitempty"`
+	Change  interface{}         `json:"change,omitempty"`
+	Error   string              `json:"error,omitempty"`
+}
+
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied
+		return
+	}
+	conn.SetReadLimit(h.wsReadLimit)
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &wsConn{
+		h:      h,
+		conn:   conn,
+		out:    make(chan wsMessage, h.wsBuffer),
+		ctx:    ctx,
+		cancel: cancel,
+		subs:   make(map[string]context.CancelFunc),
+	}
+	go c.read()
+	c.write()
+}
+
+// wsConn is a WebSocket client connection.
+type wsConn struct {
+	h      *Handler
+	conn   *websocket.Conn
+	out    chan wsMessage
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock   sync.Mutex
+	subs   map[string]context.CancelFunc // by view name
+	reason string                        // set when the client is disconnected for falling behind
+}
+
+// read handles client requests until the connection fails or is closed.
+func (c *wsConn) read() {
+	defer c.cancel()
+	for {
+		var req wsRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			return
+		}
+		switch req.Type {
+		case "subscribe":
+			c.subscribe(req)
+		case "unsubscribe":
+			c.unsubscribe(req.View)
+		default:
+			c.send(wsMessage{Type: "error", View: req.View, Error: "unknown request type `" + req.Type + "`"})
+		}
+	}
+}
+
+// write sends queued messages until the connection is done, then closes it.
+func (c *wsConn) write() {
+	defer func() {
+		c.cancel()
+		c.lock.Lock()
+		for _, cancel := range c.subs {
+			cancel()
+		}
+		reason := c.reason
+		c.lock.Unlock()
+		if reason != "" {
+			msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason)
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		}
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(c.h.writeTimeout))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// send queues a message, or disconnects the client if its queue is full.
+func (c *wsConn) send(msg wsMessage) {
+	select {
+	case c.out <- msg:
+	default:
+		c.fail("client too slow")
+	}
+}
+
+// fail disconnects a client that fell behind.
+func (c *wsConn) fail(reason string) {
+	c.lock.Lock()
+	if c.reason == "" {
+		c.reason = reason
+	}
+	c.lock.Unlock()
+	c.cancel()
+}
+
+func (c *wsConn) subscribe(req wsRequest) {
+	view, ok := c.h.views[req.View]
+	if !ok {
+		c.send(wsMessage{Type: "error", View: req.View, Error: "unknown view"})
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.lock.Lock()
+	if _, ok := c.subs[req.View]; ok {
+		c.lock.Unlock()
+		cancel()
+		c.send(wsMessage{Type: "error", View: req.View, Error: "already subscribed"})
+		return
+	}
+	c.subs[req.View] = cancel
+	c.lock.Unlock()
+	// Listen before reading the state, so that no change falls in between. The view filters changes
+	// before queuing them, so that changes to other keys cannot make the client fall behind.
+	l := listenView(view, req.Key, req.Prefix)
+	match := matchTopic(req.Key, req.Prefix)
+	var state []eventstore.Change
+	if v, ok := view.(eventstore.StatefulViewModel); ok {
+		prefix := req.Prefix
+		if req.Key != "" {
+			prefix = req.Key
+		}
+		changes, err := v.State(ctx, prefix)
+		if err != nil {
+			l.Discard()
+			c.unsubscribe(req.View)
+			c.send(wsMessage{Type: "error", View: req.View, Error: err.Error()})
+			return
+		}
+		for _, change := range changes {
+			if match(change) {
+				state = append(state, change)
+			}
+		}
+	}
+	c.send(wsMessage{Type: "state", View: req.View, Changes: state})
+	go func() {
+		defer l.Discard()
+		dropped := l.Dropped()
+		for {
+			select {
+			case change, ok := <-l.Channel():
+				if !ok {
+					c.unsubscribe(req.View)
+					return
+				}
+				if l.Dropped() != dropped {
+					c.fail("changes dropped")
+					return
+				}
+				c.send(wsMessage{Type: "change", View: req.View, Change: change})
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *wsConn) unsubscribe(view string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cancel, ok := c.subs[view]; ok {
+		cancel()
+		delete(c.subs, view)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	datastore "github.com/ipfs/go-datastore"
+	eventstore "github.com/textileio/go-eventstore"
+)
+
+// setupWebSocket serves a view named `orders`, holding orders a1, a2 and b1, and returns a WebSocket
+// connection to the gateway.
+func setupWebSocket(t *testing.T, opts ...HandlerOption) (*eventstore.StoredModel, *websocket.Conn) {
+	view := eventstore.NewStoredModel(datastore.NewMapDatastore(), 10)
+	for _, k := range []string{"/a1", "/a2", "/b1"} {
+		if err := view.Store().Put(datastore.NewKey(k), []byte(k)); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	opts = append(opts, WithView("orders", view))
+	dispatcher := eventstore.NewDispatcher(eventstore.NewTxMapDatastore())
+	server := httptest.NewServer(NewHandler(dispatcher, opts...))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/views/ws", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return view, conn
+}
+
+// receiveMessage reads the next message from the gateway.
+func receiveMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return msg
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	view, conn := setupWebSocket(t)
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", View: "missing"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if msg := receiveMessage(t, conn); msg.Type != "error" || msg.View != "missing" {
+		t.Errorf("expected an error, got %v", msg)
+	}
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", View: "orders", Prefix: "/a"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	state := receiveMessage(t, conn)
+	if state.Type != "state" || len(state.Changes) != 2 || state.Changes[0].Key != "/a1" {
+		t.Fatalf("expected the state of orders /a1 and /a2, got %v", state)
+	}
+	for _, k := range []string{"/b2", "/a3"} {
+		if err := view.Notify(eventstore.Change{Key: k, Value: k}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	change := receiveMessage(t, conn)
+	if c, ok := change.Change.(map[string]interface{}); change.Type != "change" || !ok || c["Key"] != "/a3" {
+		t.Errorf("expected the change of /a3, got %v", change)
+	}
+	if err := conn.WriteJSON(wsRequest{Type: "unsubscribe", View: "orders"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", View: "orders", Key: "/b1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if state := receiveMessage(t, conn); state.Type != "state" || len(state.Changes) != 1 {
+		t.Errorf("expected the state of order /b1, got %v", state)
+	}
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	view, conn := setupWebSocket(t, WithWebSocketBuffer(1), WithWriteTimeout(50*time.Millisecond))
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", View: "orders", Key: "/big"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if msg := receiveMessage(t, conn); msg.Type != "state" {
+		t.Fatalf("expected the state, got %v", msg)
+	}
+	// Changes larger than the connection buffers can hold, which the client does not read
+	const n = 100
+	value := bytes.Repeat([]byte("x"), 1<<18)
+	for i := 0; i < n; i++ {
+		// Changes the gateway cannot keep up with are dropped by the view, which disconnects the client too
+		view.Notify(eventstore.Change{Key: "/big", Value: value})
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; ; i++ {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if i == n {
+				t.Error("expected the client to be disconnected before receiving every change")
+			}
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				t.Error("expected the connection to be closed")
+			}
+			return
+		}
+	}
+}
+
+func TestWebSocketOtherKeys(t *testing.T) {
+	view, conn := setupWebSocket(t)
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", View: "orders", Key: "/a1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if msg := receiveMessage(t, conn); msg.Type != "state" {
+		t.Fatalf("expected the state, got %v", msg)
+	}
+	// Far more changes to other keys than the view buffers neither reach nor disconnect the client
+	for i := 0; i < 1000; i++ {
+		view.Notify(eventstore.Change{Key: "/b1", Value: i})
+	}
+	if err := view.Notify(eventstore.Change{Key: "/a1", Value: "a1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	change := receiveMessage(t, conn)
+	if c, ok := change.Change.(map[string]interface{}); change.Type != "change" || !ok || c["Key"] != "/a1" {
+		t.Errorf("expected the change of /a1, got %v", change)
+	}
+}
+
+func TestWebSocketReadLimit(t *testing.T) {
+	_, conn := setupWebSocket(t, WithWebSocketReadLimit(64))
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", View: strings.Repeat("x", 1024)}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg wsMessage
+	err := conn.ReadJSON(&msg)
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("expected the connection closed as message too big, got %v (%v)", err, msg)
+	}
+}
@@ -1,13 +1,14 @@
 // Package broadcast implements multi-listener broadcast channels.
 // See https://godoc.org/github.com/tjgq/broadcast for original implementation.
 //
-// To create an un-buffered broadcast channel, just declare a Broadcaster:
+// Broadcasters are typed by the messages they carry. To create an un-buffered broadcast channel,
+// just declare a Broadcaster:
 //
-//     var b broadcast.Broadcaster
+//     var b broadcast.Broadcaster[string]
 //
-// To create a buffered broadcast channel with capacity n, call New:
+// To create a buffered broadcast channel with capacity n, call NewBroadcaster:
 //
-//     b := broadcast.New(n)
+//     b := broadcast.NewBroadcaster[string](n)
 //
 // To add a listener to a channel, call Listen and read from Channel():
 //
@@ -20,11 +21,34 @@
 // To send to the channel, call Send:
 //
 //     b.Send("Hello world!")
-//     v <- l.Channel() // returns interface{}("Hello world!")
+//     v <- l.Channel() // returns "Hello world!"
 //
-// To remove a listener, call Discard.
+// Each listener has an overflow Policy, which decides what happens to messages sent while its channel
+// is full. Listen uses the broadcaster's default policy (DropNewest unless set with WithPolicy), and
+// ListenWithPolicy sets one explicitly:
+//
+//     l := b.ListenWithPolicy(broadcast.DropOldest)
+//     n := l.Dropped() // number of messages l has lost so far
+//
+// A broadcaster created with WithReplay or WithLatest keeps its most recent messages, and each new
+// listener receives them before any live message. This lets late listeners start from the current state:
+//
+//     b := broadcast.NewBroadcaster[string](n, broadcast.WithLatest())
+//
+// A listener can also receive only part of the messages, selected by a predicate, or by topic for
+// messages implementing Topic. Filters run before a message is enqueued:
+//
+//     l := b.ListenFiltered(func(v string) bool { return v != "" })
+//     l := b.ListenTopicPrefix("orders/")
+//
+// To remove a listener, call Discard. Listeners that are never discarded leak, so prefer ListenContext,
+// which discards the listener when the context is done:
 //
 //     l.Discard()
+//     l := b.ListenContext(ctx)
+//
+// Discarding a listener closes its channel, so a consumer ranging over Channel() terminates.
+// Both Broadcaster and Listener implement io.Closer, with Close equivalent to Discard.
 //
 // To close the broadcast channel, call Discard. Any existing or future listeners
 // will read from a closed channel:
@@ -34,12 +58,17 @@
 package broadcast
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/textileio/go-eventstore/clock"
 )
 
 // ErrClosedChannel means the caller attempted to send to one or more closed broadcast channels.
@@ -49,108 +78,424 @@ type broadcastError string
 
 func (e broadcastError) Error() string { return string(e) }
 
-// Broadcaster implements a Publisher. The zero value is a usable un-buffered channel.
-type Broadcaster struct {
+// Policy decides what happens to a message sent to a listener whose channel is full.
+type Policy int
+
+const (
+	// DropNewest drops the message being sent. This is the default.
+	DropNewest Policy = iota
+	// DropOldest evicts the oldest message waiting in the listener's channel to make room for the new
+	// one, so that the channel behaves as a ring buffer of the most recent messages. Un-buffered
+	// listeners have nothing to evict, and behave as with DropNewest.
+	DropOldest
+	// Block waits for the listener to make room. Send blocks until it does, and SendWithTimeout drops
+	// the message if it does not within the timeout.
+	Block
+	// Disconnect discards the listener and closes its channel.
+	Disconnect
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("policy(%d)", int(p))
+	}
+}
+
+// Broadcaster implements a Publisher of messages of type T. The zero value is a usable un-buffered channel.
+//
+// Sends are serialized, so that every listener receives messages in the order they were sent. A send
+// waits on all full listeners concurrently, without holding the lock taken by Listen, so a slow listener
+// delays senders, but never callers of Listen.
+type Broadcaster[T any] struct {
+	sending   sync.Mutex // serializes sends
 	m         sync.Mutex
-	listeners map[uint]chan<- interface{} // lazy init
+	listeners map[uint]*Listener[T] // lazy init
 	nextID    uint
 	capacity  int
 	closed    bool
+	clock     clock.Clock // nil means clock.Real
+	policy    Policy
+	replay    int
+	recent    []T // last replay messages sent, oldest first
+}
+
+// Option configures a Broadcaster.
+type Option func(*options)
+
+type options struct {
+	clock  clock.Clock
+	policy Policy
+	replay int
+}
+
+// WithClock sets the clock used to measure send timeouts. Defaults to the system clock.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithPolicy sets the overflow policy of listeners created with Listen. Defaults to DropNewest.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithReplay keeps the last n messages sent, and replays them to every new listener before any live
+// message. A new listener's channel is enlarged if needed to hold the replayed messages, and filtered
+// listeners only receive the replayed messages they match. Defaults to 0, i.e., new listeners only receive
+// future messages.
+func WithReplay(n int) Option {
+	return func(o *options) {
+		o.replay = n
+	}
+}
+
+// WithLatest replays the latest message sent to every new listener, so that listeners always start from
+// the current value. It is equivalent to WithReplay(1).
+func WithLatest() Option {
+	return WithReplay(1)
 }
 
 // NewBroadcaster returns a new Broadcaster with the given capacity (0 means un-buffered).
-func NewBroadcaster(n int) *Broadcaster {
-	return &Broadcaster{capacity: n}
+func NewBroadcaster[T any](n int, opts ...Option) *Broadcaster[T] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Broadcaster[T]{capacity: n, clock: o.clock, policy: o.policy, replay: o.replay}
 }
 
 // SendWithTimeout broadcasts a message to each listener's channel.
-// Sending on a closed channel causes a runtime panic.
-// This method blocks for a duration of up to `timeout` on each channel.
-// Returns error(s) if it is unable to send on a given listener's channel within `timeout` duration.
-func (b *Broadcaster) SendWithTimeout(v interface{}, timeout time.Duration) error {
-	b.m.Lock()
-	defer b.m.Unlock()
-	if b.closed {
-		return ErrClosedChannel
+// This method blocks for a duration of up to `timeout`, waiting on all full listeners at once, after which
+// each remaining full listener's policy is applied.
+// Returns error(s) for each listener that did not receive the message within `timeout` duration.
+func (b *Broadcaster[T]) SendWithTimeout(v T, timeout time.Duration) error {
+	c := b.clock
+	if c == nil {
+		c = clock.Real
 	}
-	var result *multierror.Error
-	for id, l := range b.listeners {
+	expired := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
 		select {
-		case l <- v:
-			// Success!
-		case <-time.After(timeout):
-			err := fmt.Sprintf("unable to send to listener '%d'", id)
-			result = multierror.Append(result, errors.New(err))
+		case <-c.After(timeout):
+			close(expired)
+		case <-stop:
 		}
-	}
-	return result.ErrorOrNil()
+	}()
+	return b.send(v, func(*Listener[T]) <-chan struct{} { return expired })
 }
 
 // Send broadcasts a message to each listener's channel.
-// Sending on a closed channel causes a runtime panic.
-// This method is non-blocking, and will return errors if unable to send on a given listener's channel.
-func (b *Broadcaster) Send(v interface{}) error {
+// This method is non-blocking, except for listeners with the Block policy, and will return errors if unable
+// to send on a given listener's channel.
+func (b *Broadcaster[T]) Send(v T) error {
+	never := make(chan struct{})
+	return b.send(v, func(l *Listener[T]) <-chan struct{} {
+		if l.policy == Block {
+			return never
+		}
+		return nil
+	})
+}
+
+// send offers v to every listener. wait returns, for a given listener, a channel that closes when a full
+// listener should stop being waited on, or nil to not wait at all.
+func (b *Broadcaster[T]) send(v T, wait func(*Listener[T]) <-chan struct{}) error {
+	b.sending.Lock()
+	defer b.sending.Unlock()
 	b.m.Lock()
-	defer b.m.Unlock()
 	if b.closed {
+		b.m.Unlock()
 		return ErrClosedChannel
 	}
+	listeners := make([]*Listener[T], 0, len(b.listeners))
+	for _, l := range b.listeners {
+		listeners = append(listeners, l)
+	}
+	// Recording v under the same lock as the snapshot means a new listener either receives it replayed,
+	// or live, but never both nor neither
+	if b.replay > 0 {
+		if len(b.recent) == b.replay {
+			var zero T
+			b.recent[0] = zero
+			b.recent = b.recent[1:]
+		}
+		b.recent = append(b.recent, v)
+	}
+	b.m.Unlock()
+
+	// Filters run before anything is enqueued, so rejected messages never count against a listener
+	matched := listeners[:0]
+	for _, l := range listeners {
+		if l.filter == nil || l.filter(v) {
+			matched = append(matched, l)
+		}
+	}
+	listeners = matched
+
+	errs := make([]error, len(listeners))
+	var wg sync.WaitGroup
+	for i, l := range listeners {
+		if w := wait(l); w != nil {
+			wg.Add(1)
+			go func(i int, l *Listener[T]) {
+				defer wg.Done()
+				errs[i] = l.offer(v, w)
+			}(i, l)
+		} else {
+			errs[i] = l.offer(v, nil)
+		}
+	}
+	wg.Wait()
+
 	var result *multierror.Error
-	for id, l := range b.listeners {
-		select {
-		case l <- v:
-			// Success!
-		default:
-			err := fmt.Sprintf("unable to send to listener '%d'", id)
-			result = multierror.Append(result, errors.New(err))
+	for i, err := range errs {
+		if err == nil {
+			continue
 		}
+		if err == errDisconnected {
+			listeners[i].Discard()
+			err = fmt.Errorf("listener '%d' disconnected", listeners[i].id)
+		}
+		result = multierror.Append(result, err)
 	}
 	return result.ErrorOrNil()
 }
 
-// Discard closes the channel, disabling the sending of further messages.
-func (b *Broadcaster) Discard() {
+// Discard closes the channel, disabling the sending of further messages, and closes the channels of all
+// listeners. It is safe to call more than once.
+func (b *Broadcaster[T]) Discard() {
 	b.m.Lock()
 	defer b.m.Unlock()
 	b.closed = true
 	for _, l := range b.listeners {
-		close(l)
+		l.close()
 	}
+	b.listeners = nil
+}
+
+// Close implements io.Closer. It is equivalent to Discard.
+func (b *Broadcaster[T]) Close() error {
+	b.Discard()
+	return nil
 }
 
-// Listen returns a Listener for the broadcast channel.
-func (b *Broadcaster) Listen() *Listener {
+// Listen returns a Listener for the broadcast channel, with the broadcaster's default overflow policy.
+func (b *Broadcaster[T]) Listen() *Listener[T] {
+	return b.ListenWithPolicy(b.policy)
+}
+
+// ListenWithPolicy returns a Listener for the broadcast channel, with the given overflow policy.
+func (b *Broadcaster[T]) ListenWithPolicy(p Policy) *Listener[T] {
+	return b.listen(p, nil)
+}
+
+// ListenContext returns a Listener with the broadcaster's default overflow policy, which is discarded,
+// closing its channel, when ctx is done.
+func (b *Broadcaster[T]) ListenContext(ctx context.Context) *Listener[T] {
+	l := b.Listen()
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Discard()
+		case <-l.done:
+		}
+	}()
+	return l
+}
+
+// Len returns the number of listeners that have not been discarded. It is mostly useful to detect
+// leaked listeners in tests.
+func (b *Broadcaster[T]) Len() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return len(b.listeners)
+}
+
+// ListenFiltered returns a Listener that only receives the messages for which predicate returns true,
+// with the broadcaster's default overflow policy. The predicate is called by senders, before the message
+// is enqueued, so it must be safe for concurrent use and should not block.
+func (b *Broadcaster[T]) ListenFiltered(predicate func(T) bool) *Listener[T] {
+	return b.listen(b.policy, predicate)
+}
+
+// ListenTopic returns a Listener that only receives messages implementing Topic whose topic is topic.
+func (b *Broadcaster[T]) ListenTopic(topic string) *Listener[T] {
+	return b.listen(b.policy, func(v T) bool {
+		t, ok := any(v).(Topic)
+		return ok && t.Topic() == topic
+	})
+}
+
+// ListenTopicPrefix returns a Listener that only receives messages implementing Topic whose topic
+// starts with prefix.
+func (b *Broadcaster[T]) ListenTopicPrefix(prefix string) *Listener[T] {
+	return b.listen(b.policy, func(v T) bool {
+		t, ok := any(v).(Topic)
+		return ok && strings.HasPrefix(t.Topic(), prefix)
+	})
+}
+
+func (b *Broadcaster[T]) listen(p Policy, filter func(T) bool) *Listener[T] {
 	b.m.Lock()
 	defer b.m.Unlock()
+	var replay []T
+	for _, v := range b.recent {
+		if filter == nil || filter(v) {
+			replay = append(replay, v)
+		}
+	}
+	capacity := b.capacity
+	if len(replay) > capacity {
+		capacity = len(replay)
+	}
+	l := &Listener[T]{
+		ch:     make(chan T, capacity),
+		done:   make(chan struct{}),
+		b:      b,
+		policy: p,
+		filter: filter,
+	}
+	for _, v := range replay {
+		l.ch <- v
+	}
+	if b.closed {
+		// Listeners of a discarded broadcaster read from a closed channel
+		l.close()
+		return l
+	}
 	if b.listeners == nil {
-		b.listeners = make(map[uint]chan<- interface{})
+		b.listeners = make(map[uint]*Listener[T])
 	}
 	for b.listeners[b.nextID] != nil {
 		b.nextID++
 	}
-	ch := make(chan interface{}, b.capacity)
-	if b.closed {
-		close(ch)
-	}
-	b.listeners[b.nextID] = ch
-	return &Listener{ch, b, b.nextID}
+	l.id = b.nextID
+	b.listeners[l.id] = l
+	return l
 }
 
+// Topic is implemented by messages that can be listened to by topic, with ListenTopic and
+// ListenTopicPrefix. Messages that do not implement it are never received by topic listeners.
+type Topic interface {
+	Topic() string
+}
+
+// errDisconnected signals that a listener with the Disconnect policy overflowed.
+var errDisconnected = errors.New("disconnected")
+
 // Listener implements a Subscriber to broadcast channel.
-type Listener struct {
-	ch <-chan interface{}
-	b  *Broadcaster
-	id uint
+type Listener[T any] struct {
+	ch      chan T
+	b       *Broadcaster[T]
+	id      uint
+	policy  Policy
+	filter  func(T) bool // nil means every message
+	dropped uint64       // atomic
+
+	lock   sync.Mutex // serializes sends to, and closing of, ch
+	closed bool
+	done   chan struct{}
+	once   sync.Once
 }
 
-// Discard closes the Listener, disabling the reception of further messages.
-func (l *Listener) Discard() {
+// Discard closes the Listener, disabling the reception of further messages, and closes its channel.
+// Messages already in the channel can still be read. It is safe to call more than once.
+func (l *Listener[T]) Discard() {
 	l.b.m.Lock()
 	defer l.b.m.Unlock()
-	delete(l.b.listeners, l.id)
+	if l.b.listeners[l.id] == l {
+		delete(l.b.listeners, l.id)
+	}
+	l.close()
 }
 
-// Channel returns the channel that receives broadcast messages
-func (l *Listener) Channel() <-chan interface{} {
+// Close implements io.Closer. It is equivalent to Discard.
+func (l *Listener[T]) Close() error {
+	l.Discard()
+	return nil
+}
+
+// Channel returns the channel that receives broadcast messages. It is closed when the listener or its
+// broadcaster is discarded.
+func (l *Listener[T]) Channel() <-chan T {
 	return l.ch
 }
+
+// Policy returns the listener's overflow policy.
+func (l *Listener[T]) Policy() Policy {
+	return l.policy
+}
+
+// Dropped returns the number of messages the listener has lost because its channel was full.
+func (l *Listener[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// offer sends v to the listener, applying its policy if the channel is full. If wait is not nil, a full
+// channel is waited on until wait is closed before applying the policy.
+func (l *Listener[T]) offer(v T, wait <-chan struct{}) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil
+	}
+	select {
+	case l.ch <- v:
+		return nil
+	default:
+	}
+	if wait != nil {
+		select {
+		case l.ch <- v:
+			return nil
+		case <-wait:
+		case <-l.done:
+			return nil
+		}
+	}
+	atomic.AddUint64(&l.dropped, 1)
+	switch l.policy {
+	case DropOldest:
+		select {
+		case <-l.ch:
+		default:
+		}
+		select {
+		case l.ch <- v:
+			return nil
+		default:
+		}
+	case Disconnect:
+		return errDisconnected
+	}
+	return fmt.Errorf("unable to send to listener '%d'", l.id)
+}
+
+// close closes the listener's channel exactly once, after releasing any sender blocked on it.
+func (l *Listener[T]) close() {
+	l.once.Do(func() {
+		close(l.done)
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.closed = true
+		close(l.ch)
+	})
+}
+
+// Sanity check
+var _ io.Closer = (*Broadcaster[struct{}])(nil)
+var _ io.Closer = (*Listener[struct{}])(nil)
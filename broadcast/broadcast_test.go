@@ -1,11 +1,13 @@
 package broadcast
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/textileio/go-eventstore/clock"
 )
 
 const (
@@ -14,10 +16,10 @@ const (
 	timeout = time.Second
 )
 
-type ListenFunc func(int, *Broadcaster, *sync.WaitGroup)
+type ListenFunc func(int, *Broadcaster[string], *sync.WaitGroup)
 
-func setupN(f ListenFunc) (*Broadcaster, *sync.WaitGroup) {
-	var b Broadcaster
+func setupN(f ListenFunc) (*Broadcaster[string], *sync.WaitGroup) {
+	var b Broadcaster[string]
 	var wg sync.WaitGroup
 	wg.Add(N)
 	for i := 0; i < N; i++ {
@@ -28,12 +30,12 @@ func setupN(f ListenFunc) (*Broadcaster, *sync.WaitGroup) {
 }
 
 func TestSend(t *testing.T) {
-	b, wg := setupN(func(i int, b *Broadcaster, wg *sync.WaitGroup) {
+	b, wg := setupN(func(i int, b *Broadcaster[string], wg *sync.WaitGroup) {
 		l := b.Listen()
 		wg.Done()
 		select {
 		case v := <-l.Channel():
-			if v.(string) != testStr {
+			if v != testStr {
 				t.Error("bad value received")
 			}
 		case <-time.After(timeout):
@@ -47,7 +49,7 @@ func TestSend(t *testing.T) {
 }
 
 func TestSendError(t *testing.T) {
-	var b Broadcaster
+	var b Broadcaster[string]
 	// Register listeners, but do not consume
 	b.Listen()
 	b.Listen()
@@ -68,7 +70,7 @@ func TestSendError(t *testing.T) {
 }
 
 func TestListenAndSendOnClosed(t *testing.T) {
-	var b = NewBroadcaster(5)
+	var b = NewBroadcaster[string](5)
 	b.Discard()
 	b.Listen()
 	err := b.Send(testStr)
@@ -81,7 +83,7 @@ func TestListenAndSendOnClosed(t *testing.T) {
 }
 
 func TestListenAndSendOnCloseWithTimeout(t *testing.T) {
-	var b = NewBroadcaster(5)
+	var b = NewBroadcaster[string](5)
 	b.Discard()
 	b.Listen()
 	err := b.SendWithTimeout(testStr, 0)
@@ -94,23 +96,24 @@ func TestListenAndSendOnCloseWithTimeout(t *testing.T) {
 }
 
 func TestSendWithTimeout(t *testing.T) {
-	var b Broadcaster
+	clk := clock.NewFake(time.Unix(0, 0))
+	b := NewBroadcaster[string](0, WithClock(clk))
 	var wg sync.WaitGroup
 	wg.Add(1)
-	go func(i int, b *Broadcaster, wg *sync.WaitGroup) {
+	go func(i int, b *Broadcaster[string], wg *sync.WaitGroup) {
 		l := b.Listen()
 		wg.Done()
-		time.Sleep(time.Second)
+		clk.Sleep(time.Second)
 		select {
 		case v := <-l.Channel():
-			if v.(string) != testStr {
+			if v != testStr {
 				t.Error("bad value received")
 			}
 		case <-time.After(timeout):
 			t.Error("receive timed out")
 		}
 		wg.Done()
-	}(1, &b, &wg)
+	}(1, b, &wg)
 	wg.Wait()
 	wg.Add(1)
 	if err := b.Send(testStr); err == nil {
@@ -119,6 +122,11 @@ func TestSendWithTimeout(t *testing.T) {
 	if err := b.SendWithTimeout(testStr, 0); err == nil {
 		t.Error("should error within 1 second")
 	}
+	go func() {
+		// Wait for both the listener and the sender before moving the clock
+		clk.BlockUntil(2)
+		clk.Advance(time.Second)
+	}()
 	if err := b.SendWithTimeout(testStr, 2*time.Second); err != nil {
 		t.Error("should not error within 2 seconds")
 	}
@@ -126,7 +134,7 @@ func TestSendWithTimeout(t *testing.T) {
 }
 
 func TestBroadcasterClose(t *testing.T) {
-	b, wg := setupN(func(i int, b *Broadcaster, wg *sync.WaitGroup) {
+	b, wg := setupN(func(i int, b *Broadcaster[string], wg *sync.WaitGroup) {
 		l := b.Listen()
 		wg.Done()
 		select {
@@ -145,25 +153,412 @@ func TestBroadcasterClose(t *testing.T) {
 }
 
 func TestListenerClose(t *testing.T) {
-	b, wg := setupN(func(i int, b *Broadcaster, wg *sync.WaitGroup) {
+	b, wg := setupN(func(i int, b *Broadcaster[string], wg *sync.WaitGroup) {
 		l := b.Listen()
 		if i == 0 {
 			l.Discard()
+			// A discarded listener reads from a closed channel
+			if _, ok := <-l.Channel(); ok {
+				t.Error("receive after close")
+			}
+			wg.Done()
+			return
 		}
 		wg.Done()
 		select {
 		case <-l.Channel():
-			if i == 0 {
-				t.Error("receive after close")
-			}
 		case <-time.After(timeout):
-			if i != 0 {
-				t.Error("receive timed out")
-			}
+			t.Error("receive timed out")
 		}
 		wg.Done()
 	})
-	wg.Add(N)
+	wg.Add(N - 1)
 	b.Send(testStr)
 	wg.Wait()
 }
+
+func sendN(b *Broadcaster[int], n int) error {
+	var result error
+	for i := 1; i <= n; i++ {
+		if err := b.Send(i); err != nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func drain(l *Listener[int]) []int {
+	var vs []int
+	for {
+		select {
+		case v, ok := <-l.Channel():
+			if !ok {
+				return vs
+			}
+			vs = append(vs, v)
+		default:
+			return vs
+		}
+	}
+}
+
+func TestDropNewest(t *testing.T) {
+	b := NewBroadcaster[int](2)
+	l := b.Listen()
+	if l.Policy() != DropNewest {
+		t.Errorf("unexpected default policy %s", l.Policy())
+	}
+	if err := sendN(b, 4); err == nil {
+		t.Error("expected error when dropping messages")
+	}
+	if vs := drain(l); len(vs) != 2 || vs[0] != 1 || vs[1] != 2 {
+		t.Errorf("expected oldest messages, got %v", vs)
+	}
+	if l.Dropped() != 2 {
+		t.Errorf("expected 2 dropped messages, got %d", l.Dropped())
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	b := NewBroadcaster[int](2, WithPolicy(DropOldest))
+	l := b.Listen()
+	if err := sendN(b, 4); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if vs := drain(l); len(vs) != 2 || vs[0] != 3 || vs[1] != 4 {
+		t.Errorf("expected newest messages, got %v", vs)
+	}
+	if l.Dropped() != 2 {
+		t.Errorf("expected 2 dropped messages, got %d", l.Dropped())
+	}
+}
+
+func TestBlock(t *testing.T) {
+	var b Broadcaster[int]
+	blocking := b.ListenWithPolicy(Block)
+	other := b.Listen()
+	sent := make(chan error)
+	go func() {
+		sent <- b.Send(1)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("send should block until the listener receives")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// Other callers are not stalled by the blocked send
+	b.Listen().Discard()
+	if v := <-blocking.Channel(); v != 1 {
+		t.Errorf("unexpected value %d", v)
+	}
+	if err := <-sent; err == nil {
+		t.Error("expected error for the un-buffered non-blocking listener")
+	}
+	if blocking.Dropped() != 0 || other.Dropped() != 1 {
+		t.Error("unexpected dropped counts")
+	}
+}
+
+func TestConcurrentSendOrder(t *testing.T) {
+	const senders, n = 8, 50
+	var b Broadcaster[int]
+	listeners := []*Listener[int]{b.ListenWithPolicy(Block), b.ListenWithPolicy(Block)}
+	got := make([][]int, len(listeners))
+	var readers sync.WaitGroup
+	for i, l := range listeners {
+		readers.Add(1)
+		go func(i int, l *Listener[int]) {
+			defer readers.Done()
+			for v := range l.Channel() {
+				got[i] = append(got[i], v)
+			}
+		}(i, l)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				b.Send(i*n + j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	b.Discard()
+	readers.Wait()
+	// Every listener receives the messages in the order they were sent, hence in the same order
+	if len(got[0]) != senders*n || len(got[1]) != senders*n {
+		t.Fatalf("expected %d messages, got %d and %d", senders*n, len(got[0]), len(got[1]))
+	}
+	for i := range got[0] {
+		if got[0][i] != got[1][i] {
+			t.Fatalf("listeners received messages in different orders at %d", i)
+		}
+	}
+}
+
+func TestBlockWithTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	b := NewBroadcaster[int](0, WithClock(clk), WithPolicy(Block))
+	listeners := []*Listener[int]{b.Listen(), b.Listen(), b.Listen()}
+	sent := make(chan error)
+	go func() {
+		sent <- b.SendWithTimeout(1, time.Second)
+	}()
+	// A single timeout applies to all listeners at once
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	err := <-sent
+	if multi, ok := err.(*multierror.Error); !ok || len(multi.Errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", err)
+	}
+	for _, l := range listeners {
+		if l.Dropped() != 1 {
+			t.Errorf("expected 1 dropped message, got %d", l.Dropped())
+		}
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	b := NewBroadcaster[int](1)
+	slow := b.ListenWithPolicy(Disconnect)
+	fast := b.ListenWithPolicy(DropOldest)
+	if err := sendN(b, 2); err == nil {
+		t.Error("expected error when disconnecting a listener")
+	}
+	if vs := drain(slow); len(vs) != 1 || vs[0] != 1 {
+		t.Errorf("expected first message before close, got %v", vs)
+	}
+	if _, ok := <-slow.Channel(); ok {
+		t.Error("expected disconnected listener's channel to be closed")
+	}
+	if slow.Dropped() != 1 {
+		t.Errorf("expected 1 dropped message, got %d", slow.Dropped())
+	}
+	if err := b.Send(3); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if vs := drain(fast); len(vs) != 1 || vs[0] != 3 {
+		t.Errorf("unexpected messages %v", vs)
+	}
+}
+
+func TestDiscardIdempotent(t *testing.T) {
+	b := NewBroadcaster[int](1)
+	l := b.Listen()
+	done := make(chan struct{})
+	go func() {
+		for range l.Channel() {
+		}
+		close(done)
+	}()
+	l.Discard()
+	l.Discard()
+	if err := l.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Error("ranging over a discarded listener should terminate")
+	}
+	other := b.Listen()
+	b.Discard()
+	b.Discard()
+	other.Discard()
+	if len(b.listeners) != 0 {
+		t.Error("expected no listeners after discard")
+	}
+	if _, ok := <-b.Listen().Channel(); ok {
+		t.Error("expected closed channel after broadcaster discard")
+	}
+}
+
+func TestConcurrentLifecycle(t *testing.T) {
+	b := NewBroadcaster[int](4)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			policy := Policy(i % 4)
+			for j := 0; j < 20; j++ {
+				l := b.ListenWithPolicy(policy)
+				go func() {
+					for range l.Channel() {
+					}
+				}()
+				if j%2 == 0 {
+					l.Discard()
+				}
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if j%2 == 0 {
+					b.Send(j)
+				} else {
+					b.SendWithTimeout(j, time.Millisecond)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := b.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := b.Send(0); err != ErrClosedChannel {
+		t.Error("expected closed channel error")
+	}
+}
+
+type topicMsg string
+
+func (m topicMsg) Topic() string { return string(m) }
+
+func TestListenFiltered(t *testing.T) {
+	b := NewBroadcaster[int](2)
+	even := b.ListenFiltered(func(v int) bool { return v%2 == 0 })
+	defer even.Discard()
+	if err := sendN(b, 4); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if got := drain(even); len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("expected [2 4], got %v", got)
+	}
+	if even.Dropped() != 0 {
+		t.Error("filtered messages should not count as dropped")
+	}
+}
+
+func TestListenTopic(t *testing.T) {
+	b := NewBroadcaster[topicMsg](4)
+	exact := b.ListenTopic("orders/1")
+	prefix := b.ListenTopicPrefix("orders/")
+	defer exact.Discard()
+	defer prefix.Discard()
+	for _, m := range []topicMsg{"orders/1", "orders/2", "users/1"} {
+		if err := b.Send(m); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+	b.Discard()
+	var got []topicMsg
+	for m := range exact.Channel() {
+		got = append(got, m)
+	}
+	if len(got) != 1 || got[0] != "orders/1" {
+		t.Errorf("expected [orders/1], got %v", got)
+	}
+	got = nil
+	for m := range prefix.Channel() {
+		got = append(got, m)
+	}
+	if len(got) != 2 || got[0] != "orders/1" || got[1] != "orders/2" {
+		t.Errorf("expected [orders/1 orders/2], got %v", got)
+	}
+}
+
+func TestListenTopicWithoutTopic(t *testing.T) {
+	b := NewBroadcaster[string](1)
+	l := b.ListenTopicPrefix("")
+	if err := b.Send(testStr); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	b.Discard()
+	if _, ok := <-l.Channel(); ok {
+		t.Error("messages without a topic should not be received by topic listeners")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBroadcaster[int](0, WithReplay(2))
+	if err := sendN(b, 3); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	l := b.Listen()
+	defer l.Discard()
+	if got := drain(l); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("expected [2 3], got %v", got)
+	}
+	odd := b.ListenFiltered(func(v int) bool { return v%2 == 1 })
+	defer odd.Discard()
+	if got := drain(odd); len(got) != 1 || got[0] != 3 {
+		t.Errorf("expected [3], got %v", got)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	b := NewBroadcaster[int](1, WithLatest())
+	l := b.Listen()
+	defer l.Discard()
+	if got := drain(l); len(got) != 0 {
+		t.Errorf("expected nothing before the first send, got %v", got)
+	}
+	if err := sendN(b, 2); err == nil {
+		t.Error("expected an error for the full listener")
+	}
+	late := b.Listen()
+	defer late.Discard()
+	if err := b.Send(3); err == nil {
+		t.Error("expected an error for the full listeners")
+	}
+	if got := drain(late); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected the latest message before live ones, got %v", got)
+	}
+}
+
+func TestReplayConcurrentSend(t *testing.T) {
+	const n = 200
+	b := NewBroadcaster[int](n, WithReplay(n))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sendN(b, n)
+	}()
+	// Whenever a listener joins, it must receive every message exactly once, in order
+	l := b.Listen()
+	wg.Wait()
+	b.Discard()
+	var got []int
+	for v := range l.Channel() {
+		got = append(got, v)
+	}
+	if len(got) != n {
+		t.Fatalf("expected %d messages, got %d", n, len(got))
+	}
+	for i, v := range got {
+		if v != i+1 {
+			t.Fatalf("expected message %d at %d, got %d", i+1, i, v)
+		}
+	}
+}
+
+func TestListenContext(t *testing.T) {
+	b := NewBroadcaster[string](1)
+	ctx, cancel := context.WithCancel(context.Background())
+	l := b.ListenContext(ctx)
+	if err := b.Send(testStr); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	cancel()
+	var got []string
+	for v := range l.Channel() {
+		got = append(got, v)
+	}
+	if len(got) != 1 || got[0] != testStr {
+		t.Errorf("expected buffered message before close, got %v", got)
+	}
+	if b.Len() != 0 {
+		t.Error("expected listener to be discarded")
+	}
+	// Discarding before the context is done stops watching it
+	other := b.ListenContext(context.Background())
+	other.Discard()
+	if _, ok := <-other.Channel(); ok {
+		t.Error("expected closed channel")
+	}
+}
@@ -0,0 +1,54 @@
+// Package broadcasttest provides helpers to test code that uses broadcast listeners.
+package broadcasttest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/broadcast"
+)
+
+// DefaultLeakTimeout is how long VerifyNoLeaks waits for listeners to be discarded, e.g., by ListenContext
+// after their context is done, unless set with WithLeakTimeout.
+const DefaultLeakTimeout = time.Second
+
+// Option configures VerifyNoLeaks.
+type Option func(*options)
+
+type options struct {
+	timeout time.Duration
+}
+
+// WithLeakTimeout sets how long VerifyNoLeaks waits for listeners to be discarded. Defaults to
+// DefaultLeakTimeout.
+func WithLeakTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// VerifyNoLeaks fails the test if, when it finishes, b still has listeners that were not discarded.
+// Listeners present when VerifyNoLeaks is called are not counted:
+//
+//     func TestSomething(t *testing.T) {
+//         b := broadcast.NewBroadcaster[string](1)
+//         broadcasttest.VerifyNoLeaks(t, b)
+//         ...
+//     }
+func VerifyNoLeaks[T any](t testing.TB, b *broadcast.Broadcaster[T], opts ...Option) {
+	t.Helper()
+	o := options{timeout: DefaultLeakTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	before := b.Len()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(o.timeout)
+		for b.Len() > before && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := b.Len() - before; n > 0 {
+			t.Errorf("%d broadcast listener(s) leaked", n)
+		}
+	})
+}
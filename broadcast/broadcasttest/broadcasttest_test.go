@@ -0,0 +1,52 @@
+package broadcasttest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/broadcast"
+)
+
+// recorder records failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	cleanups []func()
+	failed   bool
+}
+
+func (r *recorder) Helper()                                   {}
+func (r *recorder) Cleanup(f func())                          { r.cleanups = append(r.cleanups, f) }
+func (r *recorder) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+	b := broadcast.NewBroadcaster[string](1)
+	existing := b.Listen()
+	defer existing.Discard()
+
+	r := &recorder{TB: t}
+	VerifyNoLeaks(r, b, WithLeakTimeout(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	b.ListenContext(ctx)
+	b.Listen().Discard()
+	cancel()
+	r.finish()
+	if r.failed {
+		t.Error("unexpected leak")
+	}
+
+	r = &recorder{TB: t}
+	VerifyNoLeaks(r, b, WithLeakTimeout(10*time.Millisecond))
+	leaked := b.Listen()
+	r.finish()
+	if !r.failed {
+		t.Error("expected a leak")
+	}
+	leaked.Discard()
+}
@@ -0,0 +1,69 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFakeNow(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Error("unexpected time")
+	}
+	c.Advance(time.Second)
+	if c.Now().Sub(start) != time.Second {
+		t.Error("expected clock to advance by one second")
+	}
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Error("expected clock to be set to start")
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	c := NewFake(time.Unix(0, 0))
+	select {
+	case <-c.After(0):
+	default:
+		t.Error("zero duration should fire immediately")
+	}
+	ch := c.After(time.Second)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Error("fired too soon")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	select {
+	case v := <-ch:
+		if !v.Equal(time.Unix(1, 0)) {
+			t.Errorf("unexpected time %s", v)
+		}
+	default:
+		t.Error("expected waiter to fire")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	c := NewFake(time.Unix(0, 0))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			c.Sleep(time.Minute)
+		}()
+	}
+	c.BlockUntil(2)
+	if n := c.Waiters(); n != 2 {
+		t.Errorf("expected 2 waiters, got %d", n)
+	}
+	c.Advance(time.Minute)
+	wg.Wait()
+	if n := c.Waiters(); n != 0 {
+		t.Errorf("expected no waiters, got %d", n)
+	}
+}
@@ -0,0 +1,323 @@
+// Command eventstore inspects the event store kept in a LevelDB datastore, as created with package
+// leveldb or github.com/ipfs/go-ds-leveldb:
+//
+//     eventstore -path <dir> <command> [flags] [args]
+//
+// Commands:
+//
+//     streams                list entity streams, with their version and latest stamp
+//     version <entity-id>... show the version of entity streams
+//     events                 print events, filtered with -type, -entity, -after, -limit and -reverse
+//     tail                   print the latest -n events, filtered with -type and -entity, without
+//                            following new ones (see below)
+//     count                  count events by type
+//     verify                 check the integrity of keys and encodings, and exit with status 1 if
+//                            inconsistencies are found
+//
+// Events are pretty-printed as they are read, or printed one JSON object per line with -json. The
+// datastore is opened read-only, but LevelDB only allows a single process to open it, so the process that
+// owns the store must be stopped first. As no events can be appended meanwhile, tail cannot follow the
+// store like tail -f: follow a live store through the process that owns it instead, with the
+// /events/live endpoint of the HTTP API or the Subscribe RPC (see package api).
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/leveldb"
+)
+
+// errUsage is returned for invalid command lines.
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes a command line, and returns the exit status.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("eventstore", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("path", "", "path of the LevelDB datastore")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: eventstore -path <dir> streams|version|events|tail|count|verify [flags] [args]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	store, err := leveldb.NewDatastore(*path, &leveldb.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		fmt.Fprintf(stderr, "eventstore: opening %s: %s\n", *path, err)
+		return 1
+	}
+	defer store.Close()
+	c := &cli{d: eventstore.NewDispatcher(store), out: stdout, err: stderr}
+	commands := map[string]func(context.Context, []string) error{
+		"streams": c.streams,
+		"version": c.version,
+		"events":  c.events,
+		"tail":    c.tail,
+		"count":   c.count,
+		"verify":  c.verify,
+	}
+	name := flags.Arg(0)
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "eventstore: unknown command `%s`\n", name)
+		flags.Usage()
+		return 2
+	}
+	if err := command(ctx, flags.Args()[1:]); errors.Is(err, errUsage) {
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(stderr, "eventstore: %s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// cli implements the commands.
+type cli struct {
+	d   *eventstore.Dispatcher
+	out io.Writer
+	err io.Writer
+}
+
+func (c *cli) streams(ctx context.Context, args []string) error {
+	if _, err := c.parseFlags("streams", args); err != nil {
+		return err
+	}
+	streams, err := c.d.Streams(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ENTITY\tVERSION\tLAST")
+	for _, s := range streams {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s.EntityID, s.Version, s.Last)
+	}
+	return w.Flush()
+}
+
+func (c *cli) version(ctx context.Context, args []string) error {
+	flags, err := c.parseFlags("version", args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(c.err, "usage: eventstore version <entity-id>...")
+		return errUsage
+	}
+	for _, id := range flags.Args() {
+		version, err := c.d.Version(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "%s\t%d\n", id, version)
+	}
+	return nil
+}
+
+func (c *cli) events(ctx context.Context, args []string) error {
+	flags := c.newFlagSet("events")
+	typ := flags.String("type", "", "only print events of this type")
+	entity := flags.String("entity", "", "only print events of this entity")
+	after := flags.String("after", "", "only print events stamped after this position")
+	limit := flags.Int("limit", 0, "print at most this many events")
+	reverse := flags.Bool("reverse", false, "print the newest events first")
+	asJSON := flags.Bool("json", false, "print events as JSON, one per line")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	criteria := eventstore.Criteria{Type: *typ, EntityID: *entity, Limit: *limit, Reverse: *reverse}
+	if *after != "" {
+		var err error
+		if criteria.After, err = eventstore.ParseTimestamp(*after); err != nil {
+			return err
+		}
+	}
+	it := c.d.Events().Match(criteria).Iter(ctx)
+	defer it.Close()
+	for it.Next() {
+		if err := c.print(it.Event(), *asJSON); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func (c *cli) tail(ctx context.Context, args []string) error {
+	flags := c.newFlagSet("tail")
+	n := flags.Int("n", 10, "number of events to print")
+	typ := flags.String("type", "", "only print events of this type")
+	entity := flags.String("entity", "", "only print events of this entity")
+	asJSON := flags.Bool("json", false, "print events as JSON, one per line")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *n <= 0 {
+		return nil
+	}
+	// Read the latest n events newest first, then print them oldest first, as tail does
+	it := c.d.Events().Match(eventstore.Criteria{Type: *typ, EntityID: *entity, Limit: *n, Reverse: true}).Iter(ctx)
+	defer it.Close()
+	envs := make([]*eventstore.Envelope, 0, *n)
+	for it.Next() {
+		envs = append(envs, it.Event())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for i := len(envs) - 1; i >= 0; i-- {
+		if err := c.print(envs[i], *asJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) count(ctx context.Context, args []string) error {
+	if _, err := c.parseFlags("count", args); err != nil {
+		return err
+	}
+	counts, err := c.d.CountTypes(ctx)
+	if err != nil {
+		return err
+	}
+	types := make([]string, 0, len(counts))
+	total := 0
+	for typ, n := range counts {
+		types = append(types, typ)
+		total += n
+	}
+	sort.Strings(types)
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tCOUNT")
+	for _, typ := range types {
+		fmt.Fprintf(w, "%s\t%d\n", typ, counts[typ])
+	}
+	fmt.Fprintf(w, "total\t%d\n", total)
+	return w.Flush()
+}
+
+func (c *cli) verify(ctx context.Context, args []string) error {
+	if _, err := c.parseFlags("verify", args); err != nil {
+		return err
+	}
+	found, err := c.d.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	for _, i := range found {
+		fmt.Fprintln(c.out, i)
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("%d inconsistencies found", len(found))
+	}
+	fmt.Fprintln(c.out, "ok")
+	return nil
+}
+
+// jsonEvent is the JSON form of an event printed with -json. Time and Body are base64 encoded.
+type jsonEvent struct {
+	Stamp    string            `json:"stamp"`
+	EntityID string            `json:"entity_id"`
+	Type     string            `json:"type"`
+	Time     []byte            `json:"time,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// print prints an event, pretty-printed or as JSON.
+func (c *cli) print(env *eventstore.Envelope, asJSON bool) error {
+	e := env.Event
+	if asJSON {
+		return json.NewEncoder(c.out).Encode(jsonEvent{
+			Stamp:    env.Stamp.String(),
+			EntityID: e.EntityID(),
+			Type:     e.Type(),
+			Time:     e.Time(),
+			Body:     e.Body(),
+			Metadata: metadata(e),
+		})
+	}
+	fmt.Fprintf(c.out, "%s  %s  %s  %s\n", env.Stamp, env.Stamp.Time().UTC().Format("2006-01-02T15:04:05.000000000Z"),
+		e.EntityID(), e.Type())
+	if m := metadata(e); len(m) > 0 {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(c.out, "    %s: %s\n", k, m[k])
+		}
+	}
+	if body := formatBody(e.Body()); body != "" {
+		fmt.Fprintln(c.out, indent(body, "    "))
+	}
+	return nil
+}
+
+func metadata(e eventstore.Event) map[string]string {
+	if m, ok := e.(eventstore.EventMetadata); ok {
+		return m.Metadata()
+	}
+	return nil
+}
+
+// formatBody formats a body as indented JSON if it is JSON, as text if it is printable, or as a hex dump.
+func formatBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, body, "", "  "); err == nil {
+		return b.String()
+	}
+	if utf8.Valid(body) && strings.IndexFunc(string(body), func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	}) < 0 {
+		return string(body)
+	}
+	return strings.TrimSuffix(hex.Dump(body), "\n")
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
+
+// newFlagSet returns the flags of a command, reporting errors to stderr.
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.err)
+	return flags
+}
+
+// parseFlags parses the arguments of a command without flags of its own.
+func (c *cli) parseFlags(name string, args []string) (*flag.FlagSet, error) {
+	flags := c.newFlagSet(name)
+	if err := flags.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	return flags, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	eventstore "github.com/textileio/go-eventstore"
+)
+
+// setup creates a LevelDB datastore holding events of entities a and b, and returns its path.
+func setup(t *testing.T) string {
+	path := t.TempDir()
+	store, err := leveldb.NewDatastore(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer store.Close()
+	d := eventstore.NewDispatcher(store)
+	events := []eventstore.Event{
+		eventstore.NewEvent("a", "Created", nil, []byte(`{"n":1}`), map[string]string{"user": "alice"}),
+		eventstore.NewEvent("b", "Created", nil, []byte("plain text"), nil),
+		eventstore.NewEvent("a", "Updated", nil, []byte{0, 1, 2}, nil),
+	}
+	for _, event := range events {
+		if _, err := d.Append(context.Background(), event.EntityID(), eventstore.AnyVersion, event); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	return path
+}
+
+// runCommand runs a command line against the datastore at path, and returns its exit status and output.
+func runCommand(path string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(context.Background(), append([]string{"-path", path}, args...), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	path := setup(t)
+	tests := []struct {
+		args     []string
+		expected []string // substrings of the output, in order
+	}{
+		{[]string{"streams"}, []string{"ENTITY", "a", "2", "b", "1"}},
+		{[]string{"version", "a", "b", "c"}, []string{"a\t2", "b\t1", "c\t0"}},
+		{[]string{"count"}, []string{"Created  2", "Updated  1", "total    3"}},
+		{[]string{"events", "-entity", "a"}, []string{"a  Created", "user: alice", `"n": 1`, "a  Updated", "00 01 02"}},
+		{[]string{"events", "-type", "Created", "-json"}, []string{`"entity_id":"a"`, `"entity_id":"b"`}},
+		{[]string{"events", "-reverse", "-limit", "2"}, []string{"a  Updated", "b  Created"}},
+		{[]string{"tail", "-n", "2"}, []string{"b  Created", "plain text", "a  Updated"}},
+		{[]string{"verify"}, []string{"ok"}},
+	}
+	for _, test := range tests {
+		status, out, errs := runCommand(path, test.args...)
+		if status != 0 {
+			t.Errorf("%v: unexpected status %d: %s", test.args, status, errs)
+			continue
+		}
+		rest := out
+		for _, s := range test.expected {
+			i := strings.Index(rest, s)
+			if i < 0 {
+				t.Errorf("%v: expected `%s` in output:\n%s", test.args, s, out)
+				break
+			}
+			rest = rest[i+len(s):]
+		}
+	}
+}
+
+func TestTailLimit(t *testing.T) {
+	path := setup(t)
+	_, out, _ := runCommand(path, "tail", "-n", "1", "-json")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "Updated") {
+		t.Errorf("expected the last event only, got:\n%s", out)
+	}
+	if status, out, _ := runCommand(path, "tail", "-n", "0"); status != 0 || out != "" {
+		t.Errorf("expected no events, got status %d:\n%s", status, out)
+	}
+}
+
+func TestVerifyCorrupt(t *testing.T) {
+	path := setup(t)
+	store, err := leveldb.NewDatastore(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := store.Put(datastore.NewKey("/events/v1/bad"), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	store.Close()
+	status, out, errs := runCommand(path, "verify")
+	if status != 1 || !strings.Contains(out, "/events/v1/bad: invalid key") || !strings.Contains(errs, "1 inconsistencies") {
+		t.Errorf("expected an inconsistency, got status %d:\n%s%s", status, out, errs)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	path := setup(t)
+	for _, args := range [][]string{{}, {"unknown"}, {"version"}, {"events", "-bad"}} {
+		if status, _, _ := runCommand(path, args...); status != 2 {
+			t.Errorf("%v: expected status 2, got %d", args, status)
+		}
+	}
+	if status, _, _ := runCommand(t.TempDir()+"/missing", "streams"); status != 1 {
+		t.Errorf("expected a missing datastore to fail, got status %d", status)
+	}
+}
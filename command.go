@@ -0,0 +1,153 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrNoHandler is returned when sending a command whose type has no registered handler.
+	ErrNoHandler = errors.New("no handler for command")
+	// ErrHandlerExists is returned when registering a second handler for a command type.
+	ErrHandlerExists = errors.New("handler already registered")
+	// ErrInvalidCommand is returned by ValidationMiddleware for commands that fail validation.
+	ErrInvalidCommand = errors.New("invalid command")
+	// ErrUnauthorized is returned by AuthorizationMiddleware for commands the caller may not send.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Command is a request to change the state of the system, which handlers turn into events.
+type Command interface {
+	Type() string
+}
+
+// Validator is implemented by commands that can check their own fields. See ValidationMiddleware.
+type Validator interface {
+	Validate() error
+}
+
+// CommandHandler handles commands of a given type, returning the events that record their effect.
+type CommandHandler interface {
+	Handle(ctx context.Context, cmd Command) ([]Event, error)
+}
+
+// CommandHandlerFunc adapts a function to a CommandHandler.
+type CommandHandlerFunc func(ctx context.Context, cmd Command) ([]Event, error)
+
+// Handle calls f(ctx, cmd).
+func (f CommandHandlerFunc) Handle(ctx context.Context, cmd Command) ([]Event, error) {
+	return f(ctx, cmd)
+}
+
+// Middleware wraps a CommandHandler, e.g., to check commands before they are handled.
+type Middleware func(next CommandHandler) CommandHandler
+
+// ValidationMiddleware rejects commands implementing Validator whose Validate method fails, with an error
+// wrapping ErrInvalidCommand.
+func ValidationMiddleware(next CommandHandler) CommandHandler {
+	return CommandHandlerFunc(func(ctx context.Context, cmd Command) ([]Event, error) {
+		if v, ok := cmd.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidCommand, err)
+			}
+		}
+		return next.Handle(ctx, cmd)
+	})
+}
+
+// AuthorizationMiddleware rejects commands for which authorize fails, with an error wrapping
+// ErrUnauthorized. Callers typically pass their identity to authorize through ctx.
+func AuthorizationMiddleware(authorize func(ctx context.Context, cmd Command) error) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(ctx context.Context, cmd Command) ([]Event, error) {
+			if err := authorize(ctx, cmd); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err)
+			}
+			return next.Handle(ctx, cmd)
+		})
+	}
+}
+
+// CommandResult is the outcome of a command handled by a CommandBus.
+type CommandResult struct {
+	// Events holds the events returned by the handler, as persisted by the Dispatcher.
+	Events []*Envelope
+}
+
+// CommandBus routes commands to the handler registered for their type, through a middleware pipeline,
+// and persists the resulting events through a Dispatcher:
+//
+//     bus := eventstore.NewCommandBus(d, eventstore.ValidationMiddleware)
+//     bus.Handle("PlaceOrder", eventstore.CommandHandlerFunc(placeOrder))
+//     result, err := bus.Send(ctx, &PlaceOrder{...})
+//
+// All events returned by a handler are persisted in a single transaction, so a command takes effect
+// entirely or not at all. Handlers that need optimistic concurrency on an aggregate should save it with a
+// Repository instead, and return no events.
+type CommandBus struct {
+	d          *Dispatcher
+	middleware []Middleware
+	lock       sync.RWMutex
+	handlers   map[string]CommandHandler
+}
+
+// NewCommandBus creates a CommandBus, whose commands pass through middleware in order, the first being
+// the outermost, before reaching their handler.
+func NewCommandBus(d *Dispatcher, middleware ...Middleware) *CommandBus {
+	return &CommandBus{
+		d:          d,
+		middleware: middleware,
+		handlers:   make(map[string]CommandHandler),
+	}
+}
+
+// Handle registers the handler for a command type.
+func (b *CommandBus) Handle(commandType string, handler CommandHandler) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.handlers[commandType]; ok {
+		return fmt.Errorf("%w: `%s`", ErrHandlerExists, commandType)
+	}
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		handler = b.middleware[i](handler)
+	}
+	b.handlers[commandType] = handler
+	return nil
+}
+
+// Send handles a command, and persists the resulting events.
+func (b *CommandBus) Send(ctx context.Context, cmd Command) (*CommandResult, error) {
+	return b.send(ctx, cmd, nil)
+}
+
+// send handles a command, and persists the resulting events along with extra, in the same transaction.
+func (b *CommandBus) send(ctx context.Context, cmd Command, extra []Event) (*CommandResult, error) {
+	events, err := b.handle(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return b.persist(events, extra)
+}
+
+// handle passes a command through the middleware pipeline to its handler, and returns the resulting events
+// without persisting them.
+func (b *CommandBus) handle(ctx context.Context, cmd Command) ([]Event, error) {
+	b.lock.RLock()
+	handler, ok := b.handlers[cmd.Type()]
+	b.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: `%s`", ErrNoHandler, cmd.Type())
+	}
+	return handler.Handle(ctx, cmd)
+}
+
+// persist persists the events of a handled command along with extra, in the same transaction.
+func (b *CommandBus) persist(events, extra []Event) (*CommandResult, error) {
+	envs, err := b.d.DispatchAll(append(events, extra...)...)
+	if envs == nil && err != nil {
+		return nil, err
+	}
+	return &CommandResult{Events: envs[:len(events)]}, err
+}
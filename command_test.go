@@ -0,0 +1,101 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testCommand struct {
+	Kind   string
+	ID     string
+	Events int
+}
+
+func (c *testCommand) Type() string {
+	return c.Kind
+}
+
+func (c *testCommand) Validate() error {
+	if c.ID == "" {
+		return errors.New("missing id")
+	}
+	return nil
+}
+
+// emit returns the number of events requested by the command.
+func emit(ctx context.Context, cmd Command) ([]Event, error) {
+	c := cmd.(*testCommand)
+	events := make([]Event, c.Events)
+	for i := range events {
+		events[i] = &testEvent{ID: c.ID, Kind: "Emitted", Timestamp: time.Now()}
+	}
+	return events, nil
+}
+
+type callerKey struct{}
+
+func TestCommandBus(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	authorize := func(ctx context.Context, cmd Command) error {
+		if ctx.Value(callerKey{}) != "admin" {
+			return fmt.Errorf("`%v` may not send `%s`", ctx.Value(callerKey{}), cmd.Type())
+		}
+		return nil
+	}
+	bus := NewCommandBus(dispatcher, ValidationMiddleware, AuthorizationMiddleware(authorize))
+	if err := bus.Handle("Emit", CommandHandlerFunc(emit)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := bus.Handle("Emit", CommandHandlerFunc(emit)); !errors.Is(err, ErrHandlerExists) {
+		t.Errorf("expected handler exists, got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), callerKey{}, "admin")
+	result, err := bus.Send(ctx, &testCommand{Kind: "Emit", ID: "a", Events: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(result.Events) != 2 || result.Events[0].Event.EntityID() != "a" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 2 {
+		t.Errorf("expected 2 events persisted, got %d", version)
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		cmd  *testCommand
+		err  error
+	}{
+		{"no handler", ctx, &testCommand{Kind: "Other", ID: "a"}, ErrNoHandler},
+		{"invalid", ctx, &testCommand{Kind: "Emit"}, ErrInvalidCommand},
+		{"unauthorized", context.Background(), &testCommand{Kind: "Emit", ID: "a", Events: 1}, ErrUnauthorized},
+	}
+	for _, test := range tests {
+		if _, err := bus.Send(test.ctx, test.cmd); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 2 {
+		t.Errorf("expected rejected commands not to persist events, got version %d", version)
+	}
+}
+
+func TestCommandBusAtomic(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	bus := NewCommandBus(dispatcher)
+	bus.Handle("Mixed", CommandHandlerFunc(func(ctx context.Context, cmd Command) ([]Event, error) {
+		return []Event{
+			&testEvent{ID: "a", Kind: "Emitted"},
+			&testEvent{ID: "", Kind: "Emitted"},
+		}, nil
+	}))
+	if _, err := bus.Send(context.Background(), &testCommand{Kind: "Mixed"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected invalid event, got %v", err)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 0 {
+		t.Errorf("expected no event persisted, got version %d", version)
+	}
+}
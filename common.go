@@ -3,13 +3,17 @@ package eventstore
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	datastore "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
 )
 
 // TxMapDatastore does stuff...
+//
+// Its methods are safe for concurrent use. Accessing the embedded MapDatastore directly is not.
 type TxMapDatastore struct {
 	*datastore.MapDatastore
 	lock sync.RWMutex
@@ -21,6 +25,46 @@ func NewTxMapDatastore() *TxMapDatastore {
 	}
 }
 
+func (d *TxMapDatastore) Put(key datastore.Key, value []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.MapDatastore.Put(key, value)
+}
+
+func (d *TxMapDatastore) Delete(key datastore.Key) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.MapDatastore.Delete(key)
+}
+
+func (d *TxMapDatastore) Get(key datastore.Key) ([]byte, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.MapDatastore.Get(key)
+}
+
+func (d *TxMapDatastore) Has(key datastore.Key) (bool, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.MapDatastore.Has(key)
+}
+
+func (d *TxMapDatastore) GetSize(key datastore.Key) (int, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.MapDatastore.GetSize(key)
+}
+
+func (d *TxMapDatastore) Query(q query.Query) (query.Results, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.MapDatastore.Query(q)
+}
+
+func (d *TxMapDatastore) Batch() (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
 func (d *TxMapDatastore) NewTransaction(readOnly bool) (datastore.Txn, error) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
@@ -94,12 +138,19 @@ func (bt *SimpleTx) Discard() {
 func (bt *SimpleTx) Commit() error {
 	bt.lock.RLock()
 	defer bt.lock.RUnlock()
+	target := bt.target
+	// Readers of a TxMapDatastore see either none or all of the transaction
+	if ds, ok := target.(*TxMapDatastore); ok {
+		ds.lock.Lock()
+		defer ds.lock.Unlock()
+		target = ds.MapDatastore
+	}
 	var err error
 	for k, op := range bt.ops {
 		if op.delete {
-			err = bt.target.Delete(k)
+			err = target.Delete(k)
 		} else {
-			err = bt.target.Put(k, op.value)
+			err = target.Put(k, op.value)
 		}
 		if err != nil {
 			break
@@ -121,9 +172,45 @@ func (n *errorReducer) Reduce(event Event) error {
 	return errors.New("error")
 }
 
-type slowReducer struct{}
+type slowReducer struct {
+	clock   clock.Clock
+	running int32
+	overlap int32 // set if two reductions ever ran at once
+}
 
 func (n *slowReducer) Reduce(event Event) error {
-	time.Sleep(2 * time.Second)
+	if atomic.AddInt32(&n.running, 1) > 1 {
+		atomic.StoreInt32(&n.overlap, 1)
+	}
+	defer atomic.AddInt32(&n.running, -1)
+	n.clock.Sleep(2 * time.Second)
 	return nil
 }
+
+type testEvent struct {
+	ID        string
+	Kind      string
+	Timestamp time.Time
+	Data      []byte
+	Meta      map[string]string
+}
+
+func (n *testEvent) Body() []byte {
+	return n.Data
+}
+
+func (n *testEvent) Time() []byte {
+	return (&nullEvent{Timestamp: n.Timestamp}).Time()
+}
+
+func (n *testEvent) EntityID() string {
+	return n.ID
+}
+
+func (n *testEvent) Type() string {
+	return n.Kind
+}
+
+func (n *testEvent) Metadata() map[string]string {
+	return n.Meta
+}
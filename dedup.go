@@ -0,0 +1,205 @@
+package eventstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Deduplication
+//
+// The event IDs and idempotency keys of dispatched events are remembered under:
+//
+//     /dedup/<version>/<key>
+//
+// in the same transaction as the events themselves, where <key> is "id/" followed by the event ID,
+// "key/" followed by the idempotency key, or "schedule/" followed by the ID of the schedule the event was
+// dispatched from, escaped as in event keys. Values hold the time at which the
+// entry expires, as 8 big-endian bytes of nanoseconds, followed by the primary key of the event.
+
+// dedupNamespace is the root of deduplication keys.
+const dedupNamespace = "dedup"
+
+// DefaultDedupWindow is how long event IDs and idempotency keys are remembered by default.
+const DefaultDedupWindow = 24 * time.Hour
+
+const (
+	eventIDPrefix        = "id/"
+	idempotencyKeyPrefix = "key/"
+	scheduleIDPrefix     = "schedule/"
+)
+
+// IdentifiedEvent is implemented by events carrying a unique ID, on which Dispatch, DispatchAll and
+// Append deduplicate retries.
+type IdentifiedEvent interface {
+	Event
+	EventID() string
+}
+
+// DispatchOnce is like Dispatch, but deduplicates on a caller-supplied idempotency key: within the dedup
+// window, dispatching again with the same key returns the envelope of the original event, without
+// persisting or reducing the new one. Otherwise, it returns the envelope of the dispatched event. Events
+// implementing IdentifiedEvent are also deduplicated on their ID, as with Dispatch.
+func (d *Dispatcher) DispatchOnce(idempotencyKey string, event Event) (*Envelope, error) {
+	if idempotencyKey == "" {
+		return nil, errors.New("empty idempotency key")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := validateEvent(event); err != nil {
+		return nil, err
+	}
+	return d.dispatchOnce(idempotencyKeyPrefix+idempotencyKey, event, nil)
+}
+
+// PruneDedup deletes the expired deduplication entries, and returns how many were deleted. Expired
+// entries are ignored anyway, so pruning only reclaims space.
+func (d *Dispatcher) PruneDedup() (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result, err := d.store.Query(query.Query{Prefix: dedupPrefix().String() + "/"})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return 0, err
+	}
+	now := d.clock.clock.Now()
+	n := 0
+	for _, e := range entries {
+		if expires, _, ok := decodeDedup(e.Value); ok && expires.After(now) {
+			continue
+		}
+		if err := d.store.Delete(datastore.NewKey(e.Key)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// dispatchOnce dispatches a validated event, deduplicating on key unless it is empty, and on the event's
+// ID if it implements IdentifiedEvent. If then is not nil, it is run in the same transaction, even if the
+// event is a duplicate. It is called with the dispatcher lock held.
+func (d *Dispatcher) dispatchOnce(key string, event Event, then func(datastore.Txn) error) (*Envelope, error) {
+	now := d.clock.clock.Now()
+	var keys []string
+	for _, k := range []string{key, eventIDKey(event)} {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	var original *Envelope
+	for _, k := range keys {
+		var err error
+		if original, err = d.original(k, now); err != nil {
+			return nil, err
+		} else if original != nil {
+			break
+		}
+	}
+	if original != nil && then == nil {
+		return original, nil
+	}
+	txn, err := d.store.NewTransaction(false)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Discard()
+	if then != nil {
+		if err := then(txn); err != nil {
+			return nil, err
+		}
+	}
+	if original != nil {
+		return original, txn.Commit()
+	}
+	env := &Envelope{Stamp: d.clock.Now(), Event: event}
+	if err := d.putTxn(txn, []*Envelope{env}); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if err := txn.Put(dedupKey(k), encodeDedup(now.Add(d.dedupWindow), env.Key().Key())); err != nil {
+			return nil, err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+	d.notify(env)
+	return env, d.reduce(event)
+}
+
+// eventIDKey returns the deduplication key of an event implementing IdentifiedEvent, or "".
+func eventIDKey(event Event) string {
+	if e, ok := event.(IdentifiedEvent); ok && e.EventID() != "" {
+		return eventIDPrefix + e.EventID()
+	}
+	return ""
+}
+
+// duplicates reports whether every event is a duplicate of one dispatched within the dedup window. It is
+// called with the dispatcher lock held.
+func (d *Dispatcher) duplicates(events []Event) (bool, error) {
+	now := d.clock.clock.Now()
+	for _, event := range events {
+		key := eventIDKey(event)
+		if key == "" {
+			return false, nil
+		}
+		original, err := d.original(key, now)
+		if err != nil || original == nil {
+			return false, err
+		}
+	}
+	return len(events) > 0, nil
+}
+
+// original returns the envelope of the event dispatched with key, or nil if there is none, or its entry
+// has expired. It is called with the dispatcher lock held.
+func (d *Dispatcher) original(key string, now time.Time) (*Envelope, error) {
+	b, err := d.store.Get(dedupKey(key))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	expires, k, ok := decodeDedup(b)
+	if !ok || !expires.After(now) {
+		return nil, nil
+	}
+	value, err := d.store.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	env := &Envelope{}
+	if err := env.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func dedupPrefix() datastore.Key {
+	return datastore.NewKey(dedupNamespace).ChildString(KeyVersion)
+}
+
+func dedupKey(key string) datastore.Key {
+	return dedupPrefix().ChildString(escapeSegment(key))
+}
+
+func encodeDedup(expires time.Time, k datastore.Key) []byte {
+	b := make([]byte, 8, 8+len(k.String()))
+	binary.BigEndian.PutUint64(b, uint64(expires.UnixNano()))
+	return append(b, k.String()...)
+}
+
+func decodeDedup(b []byte) (time.Time, datastore.Key, bool) {
+	if len(b) <= 8 {
+		return time.Time{}, datastore.Key{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), datastore.RawKey(string(b[8:])), true
+}
@@ -0,0 +1,159 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/clock"
+)
+
+type identifiedEvent struct {
+	testEvent
+	UID string
+}
+
+func (e *identifiedEvent) EventID() string {
+	return e.UID
+}
+
+// countingReducer counts reduced events.
+type countingReducer struct {
+	n int
+}
+
+func (r *countingReducer) Reduce(event Event) error {
+	r.n++
+	return nil
+}
+
+func TestDispatchDedup(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithDedupWindow(time.Hour))
+	reducer := &countingReducer{}
+	dispatcher.Register(reducer)
+	event := &identifiedEvent{testEvent: testEvent{ID: "a", Kind: "Created"}, UID: "e1"}
+	for i := 0; i < 3; i++ {
+		clk.Advance(time.Second)
+		if err := dispatcher.Dispatch(event); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 1 || reducer.n != 1 {
+		t.Errorf("expected a single event dispatched, got %d stored and %d reduced", version, reducer.n)
+	}
+	// Events without an ID are never deduplicated
+	for i := 0; i < 2; i++ {
+		if err := dispatcher.Dispatch(&event.testEvent); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 3 {
+		t.Errorf("expected 3 events, got %d", version)
+	}
+	// Past the window, the ID is forgotten
+	clk.Advance(time.Hour)
+	if err := dispatcher.Dispatch(event); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 4 || reducer.n != 4 {
+		t.Errorf("expected the event dispatched again, got %d stored and %d reduced", version, reducer.n)
+	}
+}
+
+func TestDispatchOnce(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithDedupWindow(time.Minute))
+	reducer := &countingReducer{}
+	dispatcher.Register(reducer)
+	original, err := dispatcher.DispatchOnce("k1", &testEvent{ID: "a", Kind: "Created", Data: []byte("first")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	clk.Advance(time.Second)
+	retry, err := dispatcher.DispatchOnce("k1", &testEvent{ID: "a", Kind: "Created", Data: []byte("second")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if retry.Stamp != original.Stamp || string(retry.Event.Body()) != "first" {
+		t.Errorf("expected the original envelope, got %+v", retry)
+	}
+	if reducer.n != 1 {
+		t.Errorf("expected reducers to run once, got %d", reducer.n)
+	}
+	if _, err := dispatcher.DispatchOnce("", &testEvent{ID: "a", Kind: "Created"}); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+	if _, err := dispatcher.DispatchOnce("k2", &testEvent{Kind: "Created"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected invalid event, got %v", err)
+	}
+	clk.Advance(time.Minute)
+	if n, err := dispatcher.PruneDedup(); err != nil || n != 1 {
+		t.Errorf("expected 1 entry pruned, got %d (%v)", n, err)
+	}
+	if env, _ := dispatcher.DispatchOnce("k1", &testEvent{ID: "a", Kind: "Created"}); env.Stamp == original.Stamp {
+		t.Error("expected a new event after the window")
+	}
+}
+
+func TestDispatchOnceEventID(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	reducer := &countingReducer{}
+	dispatcher.Register(reducer)
+	event := &identifiedEvent{testEvent: testEvent{ID: "a", Kind: "Created"}, UID: "u1"}
+	original, err := dispatcher.DispatchOnce("k1", event)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Retries are deduplicated on the event ID, whichever way they are dispatched
+	if err := dispatcher.Dispatch(event); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	retry, err := dispatcher.DispatchOnce("k2", event)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if retry.Stamp != original.Stamp {
+		t.Errorf("expected the original envelope, got %+v", retry)
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 1 || reducer.n != 1 {
+		t.Errorf("expected the event dispatched once, got %d stored and %d reduced", version, reducer.n)
+	}
+}
+
+func TestDispatchAllDedup(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	reducer := &countingReducer{}
+	dispatcher.Register(reducer)
+	e1 := &identifiedEvent{testEvent: testEvent{ID: "a", Kind: "Created"}, UID: "e1"}
+	e2 := &identifiedEvent{testEvent: testEvent{ID: "a", Kind: "Updated"}, UID: "e2"}
+	original, err := dispatcher.Append(context.Background(), "a", 0, e1, e2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Retrying the append is not a version conflict, and stores nothing
+	retry, err := dispatcher.Append(context.Background(), "a", 0, e1, e2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(retry) != 2 || retry[0].Stamp != original[0].Stamp || retry[1].Stamp != original[1].Stamp {
+		t.Error("expected the original envelopes")
+	}
+	// Duplicates are skipped among new events, including within a batch
+	envs, err := dispatcher.DispatchAll(e2, &testEvent{ID: "a", Kind: "Deleted"}, e2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if envs[0].Stamp != original[1].Stamp || envs[2].Stamp != original[1].Stamp {
+		t.Error("expected duplicates to map to the original envelope")
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 3 || reducer.n != 3 {
+		t.Errorf("expected 3 events dispatched, got %d stored and %d reduced", version, reducer.n)
+	}
+	// A partial retry mixed with new events still checks the version
+	e3 := &identifiedEvent{testEvent: testEvent{ID: "a", Kind: "Updated"}, UID: "e3"}
+	if _, err := dispatcher.Append(context.Background(), "a", 2, e1, e3); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+}
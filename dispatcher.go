@@ -1,15 +1,17 @@
 package eventstore
 
 import (
-	"bytes"
-	"encoding/gob"
 	"sync"
+	"time"
 
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 
 	datastore "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -20,25 +22,120 @@ type Token string
 
 const prefix = "ID"
 
-var lastID = 0
-
 // Dispatcher is used to dispatch events to registered reducers.
 //
 // This is different from generic pub-sub systems because reducers are not subscribed to particular events.
 // Every event is dispatched to every registered reducer. When a given reducer is registered, it returns a `token`,
 // which can be used to deregister the reducer later.
+//
+// Each dispatched event is stamped with the dispatcher's hybrid logical clock, and the stamp determines
+// its position in the store (see EventKey).
 type Dispatcher struct {
 	store    datastore.TxnDatastore
 	reducers map[Token]Reducer
+	lastID   int
+	clock    *HLC
 	lock     sync.Mutex
+
+	subscriptions      map[*Subscription]struct{}
+	subscriptionBuffer int
+	scheduled          chan struct{} // signals the scheduler loop of new schedules
+	dedupWindow        time.Duration
+	outbox             bool
+	outboxed           chan struct{} // signals the outbox relay of new entries
+}
+
+// Option configures a Dispatcher.
+type Option func(*options)
+
+type options struct {
+	clock              clock.Clock
+	node               uint64
+	maxOffset          time.Duration
+	dedupWindow        time.Duration
+	outbox             bool
+	subscriptionBuffer int
+}
+
+// WithClock sets the physical clock backing the dispatcher's hybrid logical clock. Defaults to the system clock.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithNodeID sets the node ID stamped on every event dispatched by the dispatcher (see Timestamp).
+// Dispatchers whose events are exchanged through Ingest must use distinct node IDs. Defaults to a
+// random ID.
+func WithNodeID(id uint64) Option {
+	return func(o *options) {
+		o.node = id
+	}
+}
+
+// WithMaxOffset sets the maximum offset tolerated between the local clock and ingested stamps.
+// Defaults to DefaultMaxOffset.
+func WithMaxOffset(d time.Duration) Option {
+	return func(o *options) {
+		o.maxOffset = d
+	}
+}
+
+// WithDedupWindow sets how long the IDs of dispatched events, and idempotency keys, are remembered to
+// deduplicate retries. Defaults to DefaultDedupWindow.
+func WithDedupWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.dedupWindow = d
+	}
+}
+
+// WithOutbox adds every stored event to the outbox, in the same transaction, for a Relay to publish.
+// The outbox only shrinks as events are published, so a Relay should be running.
+func WithOutbox() Option {
+	return func(o *options) {
+		o.outbox = true
+	}
+}
+
+// WithSubscriptionBuffer sets how many live events each Subscription buffers while its consumer catches up.
+// Defaults to DefaultSubscriptionBuffer.
+func WithSubscriptionBuffer(n int) Option {
+	return func(o *options) {
+		o.subscriptionBuffer = n
+	}
 }
 
 // NewDispatcher creates a new EventDispatcher
-func NewDispatcher(store datastore.TxnDatastore) *Dispatcher {
-	return &Dispatcher{
+func NewDispatcher(store datastore.TxnDatastore, opts ...Option) *Dispatcher {
+	o := options{
+		clock:              clock.Real,
+		maxOffset:          DefaultMaxOffset,
+		dedupWindow:        DefaultDedupWindow,
+		subscriptionBuffer: DefaultSubscriptionBuffer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.node == 0 {
+		o.node = randomNodeID()
+	}
+	d := &Dispatcher{
 		store:    store,
 		reducers: make(map[Token]Reducer),
+		clock:    NewHLC(o.clock, o.node, o.maxOffset),
+
+		subscriptions:      make(map[*Subscription]struct{}),
+		subscriptionBuffer: o.subscriptionBuffer,
+		scheduled:          make(chan struct{}, 1),
+		dedupWindow:        o.dedupWindow,
+		outbox:             o.outbox,
+		outboxed:           make(chan struct{}, 1),
 	}
+	// Never issue stamps behind those already in the store, e.g., after a restart with a lagging clock
+	if last, err := d.lastKey(); err == nil {
+		d.clock.observe(last.Stamp)
+	}
+	return d
 }
 
 // Store returns the internal event store.
@@ -46,12 +143,17 @@ func (d *Dispatcher) Store() datastore.TxnDatastore {
 	return d.store
 }
 
+// Clock returns the dispatcher's hybrid logical clock.
+func (d *Dispatcher) Clock() *HLC {
+	return d.clock
+}
+
 // Register takes a reducer to be invoked with each dispatched event and returns a token for de-registration.
 func (d *Dispatcher) Register(reducer Reducer) Token {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	lastID++
-	id := Token(fmt.Sprintf("%s-%d", prefix, lastID))
+	d.lastID++
+	id := Token(fmt.Sprintf("%s-%d", prefix, d.lastID))
 	d.reducers[id] = reducer
 	return id
 }
@@ -67,43 +169,312 @@ func (d *Dispatcher) Deregister(token Token) error {
 	return nil
 }
 
-// Dispatch dispatches a payload to all registered reducers.
+// Dispatch stamps an event with the dispatcher's clock, persists it, and dispatches it to all registered reducers.
+// Events implementing IdentifiedEvent are only dispatched once per ID within the dedup window (see
+// WithDedupWindow): retries return nil without persisting or reducing the event again.
 func (d *Dispatcher) Dispatch(event Event) error {
+	_, err := d.DispatchAll(event)
+	return err
+}
+
+// DispatchAll stamps, persists, and dispatches events, in a single transaction: either all events are
+// stored, or none is. Events are stamped, and reduced, in order. Events implementing IdentifiedEvent are
+// deduplicated like with Dispatch: the envelope of the original event is returned in their place.
+func (d *Dispatcher) DispatchAll(events ...Event) ([]*Envelope, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, event := range events {
+		if err := validateEvent(event); err != nil {
+			return nil, err
+		}
+	}
+	return d.dispatchAll(events)
+}
+
+// dispatchAll stamps, persists, and dispatches validated events, skipping those whose ID was already
+// dispatched within the dedup window. It is called with the dispatcher lock held.
+// The envelopes are returned once persisted, even if a reducer fails.
+func (d *Dispatcher) dispatchAll(events []Event) ([]*Envelope, error) {
+	now := d.clock.clock.Now()
+	envs := make([]*Envelope, len(events))
+	seen := make(map[string]*Envelope)
+	var fresh []*Envelope
+	keys := make(map[*Envelope]string)
+	for i, event := range events {
+		key := eventIDKey(event)
+		if key != "" {
+			if env, ok := seen[key]; ok {
+				envs[i] = env
+				continue
+			}
+			original, err := d.original(key, now)
+			if err != nil {
+				return nil, err
+			}
+			if original != nil {
+				envs[i], seen[key] = original, original
+				continue
+			}
+		}
+		env := &Envelope{Stamp: d.clock.Now(), Event: event}
+		envs[i] = env
+		fresh = append(fresh, env)
+		if key != "" {
+			seen[key], keys[env] = env, key
+		}
+	}
+	if len(fresh) == 0 {
+		return envs, nil
+	}
+	txn, err := d.store.NewTransaction(false)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Discard()
+	if err := d.putTxn(txn, fresh); err != nil {
+		return nil, err
+	}
+	for env, key := range keys {
+		if err := txn.Put(dedupKey(key), encodeDedup(now.Add(d.dedupWindow), env.Key().Key())); err != nil {
+			return nil, err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+	var result error
+	for _, env := range fresh {
+		d.notify(env)
+		if err := d.reduce(env.Event); err != nil && result == nil {
+			result = err
+		}
+	}
+	return envs, result
+}
+
+// Ingest persists an envelope received from a peer, keeping its original stamp, and dispatches its event
+// to all registered reducers. The remote stamp is merged into the local clock, so that events dispatched
+// locally afterwards are ordered after it. Envelopes that have already been ingested are ignored, and
+// an envelope whose key is already taken by a different event is rejected with ErrStampConflict.
+func (d *Dispatcher) Ingest(env *Envelope) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	// Key format: <timestamp>/<entity-id>/<type>
-	// @todo: This is up for debate, its a 'fake' Event struct right now anyway
-	key := datastore.NewKey(string(event.Time())).ChildString(event.EntityID()).ChildString(event.Type())
-	// Encode and add an Event to event store
-	b := bytes.Buffer{}
-	e := gob.NewEncoder(&b)
-	if err := e.Encode(event); err != nil {
+	if err := validateEvent(env.Event); err != nil {
 		return err
 	}
-	if err := d.Store().Put(key, b.Bytes()); err != nil {
+	if _, err := d.clock.Update(env.Stamp); err != nil {
 		return err
 	}
+	b, err := d.store.Get(env.Key().Key())
+	if err == nil {
+		stored := &Envelope{}
+		if err := stored.UnmarshalBinary(b); err != nil {
+			return err
+		}
+		if !sameEvent(stored.Event, env.Event) {
+			return fmt.Errorf("%w: %s", ErrStampConflict, env.Key().Key())
+		}
+		return nil
+	}
+	if err != datastore.ErrNotFound {
+		return err
+	}
+	if err := d.put(env); err != nil {
+		return err
+	}
+	d.notify(env)
+	return d.reduce(env.Event)
+}
+
+// put encodes and adds envelopes to the event store, along with their secondary index entries, and the
+// updated versions of their entities and event count, in a single transaction.
+func (d *Dispatcher) put(envs ...*Envelope) error {
+	txn, err := d.store.NewTransaction(false)
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+	if err := d.putTxn(txn, envs); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// putTxn adds envelopes to the event store as part of txn.
+func (d *Dispatcher) putTxn(txn datastore.Txn, envs []*Envelope) error {
+	versions := make(map[string]int)
+	for _, env := range envs {
+		b, err := env.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		key := env.Key()
+		if err := txn.Put(key.Key(), b); err != nil {
+			return err
+		}
+		for _, k := range indexKeys(key) {
+			if err := txn.Put(k, []byte{}); err != nil {
+				return err
+			}
+		}
+		if d.outbox {
+			if err := txn.Put(outboxKey(key), []byte{}); err != nil {
+				return err
+			}
+		}
+		v, ok := versions[key.EntityID]
+		if !ok {
+			if v, err = d.version(key.EntityID); err != nil {
+				return err
+			}
+		}
+		versions[key.EntityID] = v + 1
+	}
+	for id, v := range versions {
+		if err := txn.Put(versionKey(id), encodeVersion(v)); err != nil {
+			return err
+		}
+	}
+	total, err := d.count()
+	if err != nil {
+		return err
+	}
+	return txn.Put(countKey(), encodeVersion(total+len(envs)))
+}
+
+// notify hands a persisted envelope to all live subscriptions, and wakes the outbox relay.
+func (d *Dispatcher) notify(env *Envelope) {
+	for s := range d.subscriptions {
+		s.push(env)
+	}
+	if d.outbox {
+		select {
+		case d.outboxed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// reduce runs all registered reducers concurrently, and waits for them to complete or error out.
+func (d *Dispatcher) reduce(event Event) error {
 	// Safe to fire off reducers now that event is persisted
 	g, _ := errgroup.WithContext(context.Background())
 	for _, reducer := range d.reducers {
+		reducer := reducer
 		// Launch each reducer in a separate goroutine
 		g.Go(func() error {
 			return reducer.Reduce(event)
 		})
 	}
 	// Wait for all reducers to complete or error out
-	if err := g.Wait(); err != nil {
-		return err
+	return g.Wait()
+}
+
+// lastKey returns the key of the most recent event in the store.
+func (d *Dispatcher) lastKey() (EventKey, error) {
+	result, err := d.store.Query(query.Query{
+		Prefix:   eventsPrefix().String() + "/",
+		Orders:   []query.Order{query.OrderByKeyDescending{}},
+		Limit:    1,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return EventKey{}, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return EventKey{}, err
+	}
+	if len(entries) == 0 {
+		return EventKey{}, datastore.ErrNotFound
+	}
+	return ParseEventKey(datastore.NewKey(entries[0].Key))
+}
+
+// randomNodeID returns a random, non-zero node ID.
+func randomNodeID() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
+
+// validateEvent checks that event can be encoded into a key.
+func validateEvent(event Event) error {
+	if event == nil || event.EntityID() == "" || event.Type() == "" {
+		return fmt.Errorf("%w: entity id and type must be non-empty", ErrInvalidEvent)
 	}
 	return nil
 }
 
 // Query searches the internal event store and returns a query result.
-// This is a syncronouse version of github.com/ipfs/go-datastore's Query method
-func (d *Dispatcher) Query(query query.Query) ([]query.Entry, error) {
-	result, err := d.store.Query(query)
+// This is a syncronouse version of github.com/ipfs/go-datastore's Query method.
+// Queries without a Prefix are scoped to events. Queries for events with a FilterEntity or FilterType filter
+// are served from the corresponding secondary index rather than by scanning every event.
+func (d *Dispatcher) Query(q query.Query) ([]query.Entry, error) {
+	result, err := d.query(q)
 	if err != nil {
 		return nil, err
 	}
 	return result.Rest()
 }
+
+// QueryIter is a streaming version of Query, which reads entries from the store as the returned iterator is advanced.
+func (d *Dispatcher) QueryIter(ctx context.Context, q query.Query) (*Iterator, error) {
+	result, err := d.query(q)
+	if err != nil {
+		return nil, err
+	}
+	return newIterator(ctx, result), nil
+}
+
+func (d *Dispatcher) query(q query.Query) (query.Results, error) {
+	if q.Prefix == "" {
+		q.Prefix = eventsPrefix().String()
+	}
+	if q.Prefix == eventsPrefix().String() {
+		if prefix, filters, ok := indexFor(q.Filters); ok {
+			return d.queryIndex(prefix, q, filters)
+		}
+	}
+	return d.store.Query(q)
+}
+
+// queryIndex lazily resolves the events referenced under an index prefix, then applies the rest of q to them.
+func (d *Dispatcher) queryIndex(prefix datastore.Key, q query.Query, filters []query.Filter) (query.Results, error) {
+	refs, err := d.store.Query(query.Query{
+		Prefix:   prefix.String() + "/",
+		KeysOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.Prefix = ""
+	q.Filters = filters
+	resolved := query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			ref, ok := refs.NextSync()
+			if !ok || ref.Error != nil {
+				return ref, ok
+			}
+			k, err := parseIndexKey(datastore.NewKey(ref.Key))
+			if err != nil {
+				return query.Result{Error: err}, true
+			}
+			entry := query.Entry{Key: k.Key().String()}
+			if !q.KeysOnly {
+				if entry.Value, err = d.store.Get(k.Key()); err != nil {
+					return query.Result{Error: err}, true
+				}
+			}
+			return query.Result{Entry: entry}, true
+		},
+		Close: refs.Close,
+	})
+	return query.NaiveQueryApply(q, resolved), nil
+}
@@ -1,12 +1,15 @@
 package eventstore
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	datastore "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
 )
 
 func TestNewEventDispatcher(t *testing.T) {
@@ -30,25 +33,49 @@ func TestRegister(t *testing.T) {
 
 func TestDispatchLock(t *testing.T) {
 	eventstore := NewTxMapDatastore()
-	dispatcher := NewDispatcher(eventstore)
-	dispatcher.Register(&slowReducer{})
-	event := &nullEvent{Timestamp: time.Now()}
-	t1 := time.Now()
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(eventstore, WithClock(clk))
+	reducer := &slowReducer{clock: clk}
+	dispatcher.Register(reducer)
+	event := &nullEvent{Timestamp: clk.Now()}
 	wg := &sync.WaitGroup{}
-	go func() {
-		wg.Add(1)
-		defer wg.Done()
-		if err := dispatcher.Dispatch(event); err != nil {
-			t.Error("unexpected error in dispatch call")
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			if err := dispatcher.Dispatch(event); err != nil {
+				t.Error("unexpected error in dispatch call")
+			}
+		}()
+	}
+	// Reducers for the two dispatch calls must run one after the other: were they to sleep concurrently,
+	// a single Advance would wake both, and the second BlockUntil would never return
+	for i := 0; i < 2; i++ {
+		if !waitTimeout(func() { clk.BlockUntil(1) }, time.Second) {
+			t.Fatal("timed out waiting for reducer")
 		}
-	}()
-	if err := dispatcher.Dispatch(event); err != nil {
-		t.Error("unexpected error in dispatch call")
+		clk.Advance(2 * time.Second)
 	}
-	wg.Wait()
-	t2 := time.Now()
-	if t2.Sub(t1) < (4 * time.Second) {
-		t.Error("reached this point too soon")
+	if !waitTimeout(wg.Wait, time.Second) {
+		t.Fatal("timed out waiting for dispatch calls")
+	}
+	if atomic.LoadInt32(&reducer.overlap) != 0 {
+		t.Error("expected reducers to run one after the other")
+	}
+}
+
+// waitTimeout reports whether fn returns within d.
+func waitTimeout(fn func(), d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
 	}
 }
 
@@ -87,8 +114,141 @@ func TestDispatch(t *testing.T) {
 		t.Errorf("`%s` should be `error`", err)
 	}
 	results, err = dispatcher.Query(query.Query{})
-	if len(results) > 1 {
-		t.Errorf("expected 1 result, got %d", len(results))
+	if len(results) != 2 {
+		t.Errorf("expected 2 results, got %d", len(results))
+	}
+}
+
+func TestDispatchSameTime(t *testing.T) {
+	eventstore := NewTxMapDatastore()
+	dispatcher := NewDispatcher(eventstore)
+	now := time.Now()
+	n := 3
+	for i := 0; i < n; i++ {
+		if err := dispatcher.Dispatch(&nullEvent{Timestamp: now}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+	results, err := dispatcher.Query(query.Query{
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if len(results) != n {
+		t.Fatalf("expected %d results, got %d", n, len(results))
+	}
+	var last Timestamp
+	for _, res := range results {
+		key, err := ParseEventKey(datastore.NewKey(res.Key))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if !last.Less(key.Stamp) {
+			t.Errorf("expected %s to be after %s", key.Stamp, last)
+		}
+		last = key.Stamp
+		env := &Envelope{}
+		if err := env.UnmarshalBinary(res.Value); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if env.Stamp != key.Stamp || string(env.Event.Time()) != string((&nullEvent{Timestamp: now}).Time()) {
+			t.Error("decoded envelope does not match dispatched event")
+		}
+	}
+}
+
+func TestIngest(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk))
+	remote := Timestamp{Wall: clk.Now().Add(100 * time.Millisecond).UnixNano(), Logical: 5}
+	env := &Envelope{Stamp: remote, Event: &nullEvent{Timestamp: clk.Now()}}
+	if err := dispatcher.Ingest(env); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := dispatcher.Ingest(env); err != nil {
+		t.Fatalf("unexpected error re-ingesting: %s", err.Error())
+	}
+	if err := dispatcher.Dispatch(&nullEvent{Timestamp: clk.Now()}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	results, err := dispatcher.Query(query.Query{
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Key != env.Key().Key().String() {
+		t.Error("expected ingested event to be ordered before local event")
+	}
+	future := &Envelope{
+		Stamp: Timestamp{Wall: clk.Now().Add(time.Hour).UnixNano()},
+		Event: &nullEvent{Timestamp: clk.Now()},
+	}
+	if err := dispatcher.Ingest(future); !errors.Is(err, ErrClockOffset) {
+		t.Error("expected clock offset error")
+	}
+}
+
+func TestIngestConcurrentPeers(t *testing.T) {
+	clk := clock.NewFake(time.Unix(100, 0))
+	a := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithNodeID(1))
+	b := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithNodeID(2))
+	ea, err := a.DispatchAll(&nullEvent{Timestamp: time.Unix(1, 0)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	eb, err := b.DispatchAll(&nullEvent{Timestamp: time.Unix(2, 0)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := b.Ingest(ea[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := a.Ingest(eb[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for _, d := range []*Dispatcher{a, b} {
+		results, err := d.Query(query.Query{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(results) != 2 {
+			t.Errorf("expected 2 events, got %d", len(results))
+		}
+	}
+	// A peer sharing the node ID cannot overwrite an event with a different one
+	c := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithNodeID(1))
+	ec, err := c.DispatchAll(&nullEvent{Timestamp: time.Unix(3, 0)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ec[0].Stamp != ea[0].Stamp {
+		t.Fatalf("expected colliding stamps, got %s and %s", ec[0].Stamp, ea[0].Stamp)
+	}
+	if err := a.Ingest(ec[0]); !errors.Is(err, ErrStampConflict) {
+		t.Errorf("expected stamp conflict, got %v", err)
+	}
+}
+
+func TestDispatcherResumesClock(t *testing.T) {
+	eventstore := NewTxMapDatastore()
+	clk := clock.NewFake(time.Unix(0, 0))
+	ahead := Timestamp{Wall: clk.Now().Add(time.Hour).UnixNano()}
+	env := &Envelope{Stamp: ahead, Event: &nullEvent{Timestamp: clk.Now()}}
+	b, err := env.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := eventstore.Put(env.Key().Key(), b); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	dispatcher := NewDispatcher(eventstore, WithClock(clk))
+	if !ahead.Less(dispatcher.Clock().Now()) {
+		t.Error("expected clock to resume after the last stored stamp")
 	}
 }
 
@@ -106,12 +266,13 @@ func TestValidStore(t *testing.T) {
 
 func TestQuery(t *testing.T) {
 	eventstore := NewTxMapDatastore()
-	dispatcher := NewDispatcher(eventstore)
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(eventstore, WithClock(clk))
 	var events []Event
 	n := 100
 	for i := 1; i <= n; i++ {
-		events = append(events, &nullEvent{Timestamp: time.Now()})
-		time.Sleep(time.Millisecond)
+		events = append(events, &nullEvent{Timestamp: clk.Now()})
+		clk.Advance(time.Millisecond)
 	}
 	for _, event := range events {
 		if err := dispatcher.Dispatch(event); err != nil {
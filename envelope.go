@@ -0,0 +1,128 @@
+package eventstore
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Envelope wraps an Event with the hybrid logical clock timestamp assigned to it when it was
+// dispatched. Envelopes are what the Dispatcher persists, and what peers exchange when
+// replicating events (see Dispatcher.Ingest).
+type Envelope struct {
+	Stamp Timestamp
+	Event Event
+}
+
+// Key returns the primary key under which the envelope is stored.
+func (e *Envelope) Key() EventKey {
+	return EventKey{
+		Stamp:    e.Stamp,
+		EntityID: e.Event.EntityID(),
+		Type:     e.Event.Type(),
+	}
+}
+
+// sameEvent reports whether a and b encode the same event.
+func sameEvent(a, b Event) bool {
+	if a.EntityID() != b.EntityID() || a.Type() != b.Type() ||
+		!bytes.Equal(a.Time(), b.Time()) || !bytes.Equal(a.Body(), b.Body()) {
+		return false
+	}
+	var am, bm map[string]string
+	if m, ok := a.(EventMetadata); ok {
+		am = m.Metadata()
+	}
+	if m, ok := b.(EventMetadata); ok {
+		bm = m.Metadata()
+	}
+	if len(am) != len(bm) {
+		return false
+	}
+	for k, v := range am {
+		if w, ok := bm[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// envelopeData is the gob-encoded form of an Envelope.
+type envelopeData struct {
+	Stamp    Timestamp
+	EntityID string
+	Type     string
+	Time     []byte
+	Body     []byte
+	Metadata map[string]string
+}
+
+// MarshalBinary encodes the envelope for storage.
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	b := bytes.Buffer{}
+	enc := gob.NewEncoder(&b)
+	data := envelopeData{
+		Stamp:    e.Stamp,
+		EntityID: e.Event.EntityID(),
+		Type:     e.Event.Type(),
+		Time:     e.Event.Time(),
+		Body:     e.Event.Body(),
+	}
+	if m, ok := e.Event.(EventMetadata); ok {
+		data.Metadata = m.Metadata()
+	}
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary decodes an envelope previously encoded with MarshalBinary. The decoded Event is
+// a generic implementation exposing the original event's fields, and metadata (see EventMetadata).
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	var d envelopeData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+		return err
+	}
+	e.Stamp = d.Stamp
+	e.Event = &storedEvent{
+		entityID: d.EntityID,
+		typ:      d.Type,
+		time:     d.Time,
+		body:     d.Body,
+		metadata: d.Metadata,
+	}
+	return nil
+}
+
+// storedEvent is the Event implementation produced when decoding an Envelope.
+type storedEvent struct {
+	entityID string
+	typ      string
+	time     []byte
+	body     []byte
+	metadata map[string]string
+}
+
+func (s *storedEvent) Body() []byte {
+	return s.body
+}
+
+func (s *storedEvent) Time() []byte {
+	return s.time
+}
+
+func (s *storedEvent) EntityID() string {
+	return s.entityID
+}
+
+func (s *storedEvent) Type() string {
+	return s.typ
+}
+
+func (s *storedEvent) Metadata() map[string]string {
+	return s.metadata
+}
+
+// Sanity check
+var _ Event = (*storedEvent)(nil)
+var _ EventMetadata = (*storedEvent)(nil)
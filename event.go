@@ -15,6 +15,34 @@ type Event interface {
 	Type() string
 }
 
+// EventMetadata is implemented by events carrying metadata, which is persisted along with them, and
+// exposed by the events decoded from the store.
+type EventMetadata interface {
+	Metadata() map[string]string
+}
+
+// CorrelationIDKey is the metadata key of the ID correlating the events of a workflow (see Saga).
+const CorrelationIDKey = "correlation-id"
+
+// MetadataValue returns the value of key in the event's metadata, if any.
+func MetadataValue(event Event, key string) string {
+	if m, ok := event.(EventMetadata); ok {
+		return m.Metadata()[key]
+	}
+	return ""
+}
+
+// CorrelationID returns the correlation ID in the event's metadata, if any.
+func CorrelationID(event Event) string {
+	return MetadataValue(event, CorrelationIDKey)
+}
+
+// NewEvent returns a generic Event with the given fields, like the events decoded from the store. It
+// is mostly useful to rebuild events received over the network.
+func NewEvent(entityID, typ string, time, body []byte, metadata map[string]string) Event {
+	return &storedEvent{entityID: entityID, typ: typ, time: time, body: body, metadata: metadata}
+}
+
 type nullEvent struct {
 	Timestamp time.Time
 }
@@ -0,0 +1,243 @@
+package eventstore
+
+import (
+	"context"
+	"math"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// EventQuery is a fluent, typed query over the events in a Dispatcher's store. Each method returns a
+// modified copy, so partially built queries can be safely reused:
+//
+//     orders := d.Events().OfType("OrderPlaced")
+//     recent, err := orders.Between(t1, t2).Limit(10).Reverse().Run()
+//
+// Queries compile to a scan of the most selective key space available (the entity index, the type index,
+// or the primary keys), restricted to the key range given by time and position bounds. Stores that
+// implement RangeQuerier only read the keys in range; others scan the common prefix of the range bounds.
+type EventQuery struct {
+	d        *Dispatcher
+	typ      string
+	entityID string
+	from     *Timestamp
+	to       *Timestamp
+	after    *Timestamp
+	limit    int
+	reverse  bool
+	pageSize int
+}
+
+// Events starts a new query over all events, in causal order.
+func (d *Dispatcher) Events() EventQuery {
+	return EventQuery{d: d}
+}
+
+// OfType restricts the query to events of the given type.
+func (q EventQuery) OfType(typ string) EventQuery {
+	q.typ = typ
+	return q
+}
+
+// ForEntity restricts the query to events of the given entity.
+func (q EventQuery) ForEntity(id string) EventQuery {
+	q.entityID = id
+	return q
+}
+
+// Between restricts the query to events stamped at or after from, and before to. A zero from or to
+// leaves that side unbounded.
+func (q EventQuery) Between(from, to time.Time) EventQuery {
+	q.from, q.to = nil, nil
+	if !from.IsZero() {
+		q.from = &Timestamp{Wall: from.UnixNano()}
+	}
+	if !to.IsZero() {
+		q.to = &Timestamp{Wall: to.UnixNano()}
+	}
+	return q
+}
+
+// After restricts the query to events stamped strictly after position, e.g., the Stamp of the last
+// event seen by the caller.
+func (q EventQuery) After(position Timestamp) EventQuery {
+	q.after = &position
+	return q
+}
+
+// Limit caps the number of events returned. Zero means no limit.
+func (q EventQuery) Limit(n int) EventQuery {
+	q.limit = n
+	return q
+}
+
+// Reverse returns events newest first.
+func (q EventQuery) Reverse() EventQuery {
+	q.reverse = true
+	return q
+}
+
+// Run executes the query and returns the decoded events. Use Iter to process large results without
+// holding them all in memory.
+func (q EventQuery) Run() ([]*Envelope, error) {
+	it := q.Iter(context.Background())
+	defer it.Close()
+	var envs []*Envelope
+	for it.Next() {
+		envs = append(envs, it.Event())
+	}
+	return envs, it.Err()
+}
+
+// count returns the number of events matching q, without reading them.
+func (q EventQuery) count(ctx context.Context) (int, error) {
+	s := q.compile()
+	s.query.KeysOnly = true
+	results, err := q.d.open(s)
+	if err != nil {
+		return 0, err
+	}
+	it := newIterator(ctx, results)
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		n++
+	}
+	return n, it.Err()
+}
+
+// RangeQuerier is implemented by datastores that can seek to a range of keys, such as the LevelDB
+// datastore of package leveldb, so that bounded event queries don't read the keys outside their range.
+type RangeQuerier interface {
+	// QueryRange is like Query, restricted to the keys at or after start, and before end. An empty end
+	// means no upper bound.
+	QueryRange(q query.Query, start, end string) (query.Results, error)
+}
+
+// scan is a compiled EventQuery: a datastore query restricted to the keys in [start, end).
+type scan struct {
+	query   query.Query
+	start   string
+	end     string // empty for no upper bound
+	indexed bool   // whether the query scans an index
+}
+
+// compile translates q into a scan.
+func (q EventQuery) compile() scan {
+	var prefix datastore.Key
+	var filters []query.Filter
+	indexed := true
+	switch {
+	case q.entityID != "":
+		prefix = indexPrefix(entityIndex, q.entityID)
+		if q.typ != "" {
+			filters = append(filters, lastSegment(escapeSegment(q.typ)))
+		}
+	case q.typ != "":
+		prefix = indexPrefix(typeIndex, q.typ)
+	default:
+		prefix, indexed = eventsPrefix(), false
+	}
+	// Every scanned key space orders entries by stamp directly under its prefix
+	bound := func(ts Timestamp) string {
+		return prefix.ChildString(encodeTime(ts.Wall)).ChildString(encodeSeq(ts)).String()
+	}
+	var start, end string
+	if q.from != nil {
+		start = bound(*q.from)
+	}
+	if q.to != nil {
+		end = bound(*q.to)
+	}
+	if q.after != nil {
+		if *q.after == maxTimestamp {
+			// Nothing is stamped after the greatest timestamp
+			filters = append(filters, matchNone{})
+		} else if b := bound(q.after.next()); b > start {
+			start = b
+		}
+	}
+	order := query.Order(query.OrderByKey{})
+	if q.reverse {
+		order = query.OrderByKeyDescending{}
+	}
+	return scan{
+		query: query.Query{
+			Prefix:   prefix.String() + "/",
+			Filters:  filters,
+			Orders:   []query.Order{order},
+			Limit:    q.limit,
+			KeysOnly: indexed,
+		},
+		start:   start,
+		end:     end,
+		indexed: indexed,
+	}
+}
+
+// open runs a scan on the dispatcher's store.
+func (d *Dispatcher) open(s scan) (query.Results, error) {
+	if r, ok := d.store.(RangeQuerier); ok {
+		return r.QueryRange(s.query, s.start, s.end)
+	}
+	// Narrow the prefix down to what the range bounds have in common, and filter out the keys outside
+	q := s.query
+	q.Filters = append([]query.Filter(nil), q.Filters...)
+	if s.start != "" {
+		q.Filters = append(q.Filters, query.FilterKeyCompare{Op: query.GreaterThanOrEqual, Key: s.start})
+	}
+	if s.end != "" {
+		q.Filters = append(q.Filters, query.FilterKeyCompare{Op: query.LessThan, Key: s.end})
+		if p := commonPrefix(s.start, s.end); len(p) > len(q.Prefix) {
+			q.Prefix = p
+		}
+	}
+	return d.store.Query(q)
+}
+
+// commonPrefix returns the longest common prefix of a and b.
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// decode returns the envelope stored under a primary key entry, or referenced by an index key entry.
+func (d *Dispatcher) decode(e query.Entry, indexed bool) (*Envelope, error) {
+	value := e.Value
+	if indexed {
+		k, err := parseIndexKey(datastore.NewKey(e.Key))
+		if err != nil {
+			return nil, err
+		}
+		if value, err = d.store.Get(k.Key()); err != nil {
+			return nil, err
+		}
+	}
+	env := &Envelope{}
+	if err := env.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// maxTimestamp is the greatest timestamp, which has no next.
+var maxTimestamp = Timestamp{Wall: math.MaxInt64, Logical: math.MaxUint32, Node: math.MaxUint64}
+
+// matchNone matches no entry.
+type matchNone struct{}
+
+func (matchNone) Filter(query.Entry) bool {
+	return false
+}
+
+// lastSegment matches entries whose key ends with the given segment.
+type lastSegment string
+
+func (f lastSegment) Filter(e query.Entry) bool {
+	return datastore.RawKey(e.Key).BaseNamespace() == string(f)
+}
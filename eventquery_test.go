@@ -0,0 +1,139 @@
+package eventstore
+
+import (
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
+)
+
+// setupEvents dispatches events for entities a, b and c, alternating between two types, one second apart.
+func setupEvents(t *testing.T) (*Dispatcher, *clock.Fake) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk))
+	for i := 0; i < 12; i++ {
+		clk.Advance(time.Second)
+		event := &testEvent{
+			ID:        string(rune('a' + i%3)),
+			Kind:      []string{"Created", "Updated"}[i%2],
+			Timestamp: clk.Now(),
+		}
+		if err := dispatcher.Dispatch(event); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	return dispatcher, clk
+}
+
+func TestEventQuery(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	tests := []queryTest{
+		{"all", dispatcher.Events(), []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{"type", dispatcher.Events().OfType("Created"), []int64{1, 3, 5, 7, 9, 11}},
+		{"entity", dispatcher.Events().ForEntity("b"), []int64{2, 5, 8, 11}},
+		{"entity and type", dispatcher.Events().ForEntity("b").OfType("Updated"), []int64{2, 8}},
+		{"between", dispatcher.Events().Between(time.Unix(3, 0), time.Unix(6, 0)), []int64{3, 4, 5}},
+		{"before", dispatcher.Events().Between(time.Time{}, time.Unix(3, 0)), []int64{1, 2}},
+		{"since", dispatcher.Events().Between(time.Unix(11, 0), time.Time{}), []int64{11, 12}},
+		{"between and after", dispatcher.Events().Between(time.Unix(3, 0), time.Unix(6, 0)).After(Timestamp{Wall: int64(3 * time.Second), Node: dispatcher.Clock().Node()}), []int64{4, 5}},
+		{"after", dispatcher.Events().OfType("Updated").After(Timestamp{Wall: int64(6 * time.Second), Node: dispatcher.Clock().Node()}), []int64{8, 10, 12}},
+		{"limit", dispatcher.Events().ForEntity("a").Limit(2), []int64{1, 4}},
+		{"reverse", dispatcher.Events().ForEntity("a").Reverse().Limit(2), []int64{10, 7}},
+		{"empty", dispatcher.Events().OfType("Deleted"), nil},
+		{"after last", dispatcher.Events().After(maxTimestamp), nil},
+	}
+	checkQueries(t, tests)
+}
+
+type queryTest struct {
+	name  string
+	query EventQuery
+	walls []int64 // expected stamps, in seconds
+}
+
+func checkQueries(t *testing.T, tests []queryTest) {
+	for _, test := range tests {
+		envs, err := test.query.Run()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err.Error())
+		}
+		if len(envs) != len(test.walls) {
+			t.Errorf("%s: expected %d events, got %d", test.name, len(test.walls), len(envs))
+			continue
+		}
+		for i, env := range envs {
+			if env.Stamp.Wall != test.walls[i]*int64(time.Second) {
+				t.Errorf("%s: unexpected stamp %s at position %d", test.name, env.Stamp, i)
+			}
+		}
+	}
+}
+
+func TestEventQueryReuse(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	base := dispatcher.Events().OfType("Created")
+	limited, err := base.Limit(1).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	all, err := base.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(limited) != 1 || len(all) != 6 {
+		t.Error("derived queries should not modify their base")
+	}
+}
+
+// rangeStore implements RangeQuerier by filtering queries, and records the ranges queried.
+type rangeStore struct {
+	datastore.TxnDatastore
+	ranges [][2]string
+}
+
+func (r *rangeStore) QueryRange(q query.Query, start, end string) (query.Results, error) {
+	r.ranges = append(r.ranges, [2]string{start, end})
+	q.Filters = append(q.Filters, query.FilterKeyCompare{Op: query.GreaterThanOrEqual, Key: start})
+	if end != "" {
+		q.Filters = append(q.Filters, query.FilterKeyCompare{Op: query.LessThan, Key: end})
+	}
+	return r.TxnDatastore.Query(q)
+}
+
+func TestEventQueryRange(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	store := &rangeStore{TxnDatastore: dispatcher.store}
+	dispatcher.store = store
+	node := dispatcher.Clock().Node()
+	checkQueries(t, []queryTest{
+		{"all", dispatcher.Events(), []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{"between", dispatcher.Events().OfType("Created").Between(time.Unix(3, 0), time.Unix(9, 0)), []int64{3, 5, 7}},
+		{"after", dispatcher.Events().ForEntity("a").After(Timestamp{Wall: int64(4 * time.Second), Node: node}), []int64{7, 10}},
+		{"reverse", dispatcher.Events().Between(time.Unix(3, 0), time.Unix(6, 0)).Reverse(), []int64{5, 4, 3}},
+	})
+	if len(store.ranges) != 4 {
+		t.Fatalf("expected 4 range queries, got %d", len(store.ranges))
+	}
+	from, to := Timestamp{Wall: int64(3 * time.Second)}, Timestamp{Wall: int64(9 * time.Second)}
+	prefix := indexPrefix(typeIndex, "Created")
+	expected := [2]string{
+		prefix.ChildString(encodeTime(from.Wall)).ChildString(encodeSeq(from)).String(),
+		prefix.ChildString(encodeTime(to.Wall)).ChildString(encodeSeq(to)).String(),
+	}
+	if store.ranges[1] != expected {
+		t.Errorf("expected range %v, got %v", expected, store.ranges[1])
+	}
+	if store.ranges[0] != [2]string{} || store.ranges[2][0] == "" || store.ranges[2][1] != "" {
+		t.Errorf("expected unbounded sides to be empty, got %v", store.ranges)
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, test := range [][3]string{{"", "", ""}, {"abc", "abd", "ab"}, {"ab", "abc", "ab"}, {"x", "y", ""}} {
+		if p := commonPrefix(test[0], test[1]); p != test[2] {
+			t.Errorf("expected `%s` for `%s` and `%s`, got `%s`", test[2], test[0], test[1], p)
+		}
+	}
+}
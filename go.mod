@@ -1,15 +1,26 @@
 module github.com/textileio/go-eventstore
 
-go 1.12
+go 1.18
 
 require (
-	github.com/coreos/etcd v3.3.15+incompatible
+	github.com/gorilla/websocket v1.5.0
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/ipfs/go-datastore v0.1.0
-	github.com/jonboulle/clockwork v0.1.0 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
-	github.com/pkg/errors v0.8.0
-	github.com/prometheus/client_golang v1.1.0 // indirect
+	github.com/ipfs/go-ds-leveldb v0.1.0
+	github.com/syndtr/goleveldb v1.0.0
 	golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/jbenet/goprocess v0.0.0-20160826012719-b497e2f366b8 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 )
@@ -0,0 +1,365 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/textileio/go-eventstore/clock"
+)
+
+const (
+	// DefaultAckTimeout is how long a group member has to acknowledge an event before it is redelivered.
+	DefaultAckTimeout = 30 * time.Second
+	// DefaultMemberBuffer is the number of deliveries a group member can have waiting on its channel.
+	DefaultMemberBuffer = 16
+	// DefaultMaxPending is the number of unacknowledged events a group holds before it stops reading new ones.
+	DefaultMaxPending = 1024
+)
+
+var (
+	// ErrGroupClosed is returned when joining or acknowledging on a closed group.
+	ErrGroupClosed = errors.New("group closed")
+	// ErrNotDelivered is returned when acknowledging an event that is not outstanding for the member.
+	ErrNotDelivered = errors.New("event not delivered to member")
+	// ErrMemberExists is returned when joining a group with the ID of an existing member.
+	ErrMemberExists = errors.New("member already exists")
+)
+
+// GroupOption configures a Group.
+type GroupOption func(*Group)
+
+// WithAckTimeout sets how long a member has to acknowledge an event before it is redelivered.
+// Defaults to DefaultAckTimeout.
+func WithAckTimeout(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ackTimeout = d
+	}
+}
+
+// WithMemberBuffer sets the capacity of each member's channel. Defaults to DefaultMemberBuffer.
+func WithMemberBuffer(n int) GroupOption {
+	return func(g *Group) {
+		g.buffer = n
+	}
+}
+
+// WithMaxPending sets how many unacknowledged events the group holds before it stops reading new ones
+// until members catch up. Defaults to DefaultMaxPending.
+func WithMaxPending(n int) GroupOption {
+	return func(g *Group) {
+		g.maxPending = n
+	}
+}
+
+// Group is a named consumer group, whose members share the work of processing events rather than each
+// processing every event.
+//
+// Each event is delivered to a single member, chosen by hashing the event's EntityID over the current
+// members, so that all events of an entity go to the same member. An entity's next event is only
+// delivered once the previous one has been acknowledged, which preserves per-entity ordering. Events not
+// acknowledged within the ack timeout are redelivered, so delivery is at-least-once. Members can join and
+// leave at any time; outstanding events of a departed member are redelivered to the new owner.
+//
+// The group's position, up to which every event has been acknowledged, is persisted like a named
+// Subscription's, so a restarted group resumes where it left off.
+type Group struct {
+	name       string
+	clock      clock.Clock
+	ackTimeout time.Duration
+	buffer     int
+	maxPending int
+	sub        *Subscription
+
+	joins  chan groupJoin
+	leaves chan *Member
+	acks   chan groupAck
+	done   chan struct{}
+
+	// State below is owned by the run loop
+	members map[string]*Member
+	streams map[string]*groupStream
+	order   []*groupEntry // unacknowledged events, in delivery order
+}
+
+// groupStream holds the undelivered events of a single entity.
+type groupStream struct {
+	queue    []*groupEntry
+	inflight *groupEntry
+}
+
+type groupEntry struct {
+	env      *Envelope
+	member   *Member // nil until delivered
+	deadline time.Time
+	acked    bool
+}
+
+type groupJoin struct {
+	member *Member
+	reply  chan error
+}
+
+type groupAck struct {
+	member   *Member
+	entityID string
+	key      datastore.Key
+	reply    chan error
+}
+
+// Member is a member of a consumer Group.
+type Member struct {
+	id string
+	g  *Group
+	ch chan *Envelope
+}
+
+// NewGroup starts the named consumer group for the events matching filter, resuming from its persisted
+// position. The group stops when ctx is done or Close is called.
+func (d *Dispatcher) NewGroup(ctx context.Context, name string, filter SubscriptionFilter, opts ...GroupOption) (*Group, error) {
+	sub, err := d.SubscribeNamed(ctx, groupCursor(name), filter)
+	if err != nil {
+		return nil, err
+	}
+	g := &Group{
+		name:       name,
+		clock:      d.clock.clock,
+		ackTimeout: DefaultAckTimeout,
+		buffer:     DefaultMemberBuffer,
+		maxPending: DefaultMaxPending,
+		sub:        sub,
+		joins:      make(chan groupJoin),
+		leaves:     make(chan *Member),
+		acks:       make(chan groupAck),
+		done:       make(chan struct{}),
+		members:    make(map[string]*Member),
+		streams:    make(map[string]*groupStream),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	go g.run()
+	return g, nil
+}
+
+// Join adds a member to the group.
+func (g *Group) Join(id string) (*Member, error) {
+	m := &Member{id: id, g: g, ch: make(chan *Envelope, g.buffer)}
+	reply := make(chan error, 1)
+	select {
+	case g.joins <- groupJoin{member: m, reply: reply}:
+	case <-g.done:
+		return nil, ErrGroupClosed
+	}
+	if err := <-reply; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close stops the group, and closes all member channels.
+func (g *Group) Close() error {
+	err := g.sub.Close()
+	<-g.done
+	return err
+}
+
+// Err returns the error, if any, that stopped the group, once it has stopped, e.g., ErrSubscriptionOverflow
+// if members fell too far behind live events.
+func (g *Group) Err() error {
+	<-g.done
+	return g.sub.Err()
+}
+
+// ID returns the member's ID.
+func (m *Member) ID() string {
+	return m.id
+}
+
+// Channel returns the channel that receives the member's events. It is closed when the member leaves
+// the group, or the group is closed.
+func (m *Member) Channel() <-chan *Envelope {
+	return m.ch
+}
+
+// Ack acknowledges that the member has processed env.
+func (m *Member) Ack(env *Envelope) error {
+	reply := make(chan error, 1)
+	select {
+	case m.g.acks <- groupAck{member: m, entityID: env.Event.EntityID(), key: env.Key().Key(), reply: reply}:
+		return <-reply
+	case <-m.g.done:
+		return ErrGroupClosed
+	}
+}
+
+// Leave removes the member from the group. Its unacknowledged events are redelivered to other members.
+func (m *Member) Leave() {
+	select {
+	case m.g.leaves <- m:
+	case <-m.g.done:
+	}
+}
+
+func (g *Group) run() {
+	defer func() {
+		for _, m := range g.members {
+			close(m.ch)
+		}
+		close(g.done)
+	}()
+	// tick fires at the earliest deadline of the events in flight, if any
+	var tick <-chan time.Time
+	var tickAt time.Time
+	for {
+		// Apply back-pressure to the subscription while too many events are pending
+		var events <-chan *Envelope
+		if len(g.order) < g.maxPending {
+			events = g.sub.Channel()
+		}
+		select {
+		case env, ok := <-events:
+			if !ok {
+				return
+			}
+			entry := &groupEntry{env: env}
+			g.order = append(g.order, entry)
+			s := g.stream(env.Event.EntityID())
+			s.queue = append(s.queue, entry)
+		case j := <-g.joins:
+			if _, ok := g.members[j.member.id]; ok {
+				j.reply <- fmt.Errorf("%w: `%s`", ErrMemberExists, j.member.id)
+				break
+			}
+			g.members[j.member.id] = j.member
+			j.reply <- nil
+		case m := <-g.leaves:
+			if g.members[m.id] != m {
+				break
+			}
+			delete(g.members, m.id)
+			close(m.ch)
+			g.rebalance()
+		case a := <-g.acks:
+			a.reply <- g.ack(a)
+		case now := <-tick:
+			tick, tickAt = nil, time.Time{}
+			g.expire(now)
+		}
+		g.deliver()
+		if deadline := g.deadline(); !deadline.IsZero() && (tick == nil || deadline.Before(tickAt)) {
+			tick, tickAt = g.clock.After(deadline.Sub(g.clock.Now())), deadline
+		}
+	}
+}
+
+// deadline returns the earliest deadline of the events in flight, or the zero time if there are none.
+func (g *Group) deadline() time.Time {
+	var earliest time.Time
+	for _, s := range g.streams {
+		if s.inflight != nil && s.inflight.member != nil && (earliest.IsZero() || s.inflight.deadline.Before(earliest)) {
+			earliest = s.inflight.deadline
+		}
+	}
+	return earliest
+}
+
+func (g *Group) stream(entityID string) *groupStream {
+	s, ok := g.streams[entityID]
+	if !ok {
+		s = &groupStream{}
+		g.streams[entityID] = s
+	}
+	return s
+}
+
+// owner returns the member responsible for an entity, using rendezvous hashing so that membership
+// changes only move the entities of the members involved.
+func (g *Group) owner(entityID string) *Member {
+	var owner *Member
+	var best uint64
+	for id, m := range g.members {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+		h.Write([]byte(entityID))
+		if score := h.Sum64(); owner == nil || score > best || (score == best && id < owner.id) {
+			owner, best = m, score
+		}
+	}
+	return owner
+}
+
+// deliver hands each entity's next event to its owner, if the entity has no event in flight.
+func (g *Group) deliver() {
+	for id, s := range g.streams {
+		if s.inflight == nil {
+			if len(s.queue) == 0 {
+				delete(g.streams, id)
+				continue
+			}
+			s.inflight = s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+		}
+		if s.inflight.member != nil {
+			continue
+		}
+		m := g.owner(id)
+		if m == nil {
+			continue
+		}
+		select {
+		case m.ch <- s.inflight.env:
+			s.inflight.member = m
+			s.inflight.deadline = g.clock.Now().Add(g.ackTimeout)
+		default:
+			// Member is saturated, retry on the next loop iteration
+		}
+	}
+}
+
+// rebalance marks in-flight events whose member has left for redelivery.
+func (g *Group) rebalance() {
+	for _, s := range g.streams {
+		if s.inflight != nil && s.inflight.member != nil && g.members[s.inflight.member.id] != s.inflight.member {
+			s.inflight.member = nil
+		}
+	}
+}
+
+// expire marks in-flight events that are past their deadline for redelivery.
+func (g *Group) expire(now time.Time) {
+	for _, s := range g.streams {
+		if s.inflight != nil && s.inflight.member != nil && !now.Before(s.inflight.deadline) {
+			s.inflight.member = nil
+		}
+	}
+}
+
+func (g *Group) ack(a groupAck) error {
+	s, ok := g.streams[a.entityID]
+	if !ok || s.inflight == nil || s.inflight.member != a.member || s.inflight.env.Key().Key() != a.key {
+		return ErrNotDelivered
+	}
+	s.inflight.acked = true
+	s.inflight = nil
+	// Advance the persisted position past every event acknowledged so far
+	var last *Envelope
+	for len(g.order) > 0 && g.order[0].acked {
+		last = g.order[0].env
+		g.order[0] = nil
+		g.order = g.order[1:]
+	}
+	if last != nil {
+		return g.sub.Ack(last.Stamp)
+	}
+	return nil
+}
+
+func groupCursor(name string) string {
+	return "group/" + name
+}
@@ -0,0 +1,175 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func next(t *testing.T, m *Member) *Envelope {
+	select {
+	case env, ok := <-m.Channel():
+		if !ok {
+			t.Fatalf("member %s channel closed", m.ID())
+		}
+		return env
+	case <-time.After(time.Second):
+		t.Fatalf("member %s receive timed out", m.ID())
+	}
+	return nil
+}
+
+func nothing(t *testing.T, m *Member) {
+	select {
+	case env := <-m.Channel():
+		t.Fatalf("unexpected event %s for member %s", env.Stamp, m.ID())
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestGroup(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	g, err := dispatcher.NewGroup(context.Background(), "workers", SubscriptionFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer g.Close()
+	var members []*Member
+	for _, id := range []string{"one", "two", "three"} {
+		m, err := g.Join(id)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		members = append(members, m)
+	}
+	if _, err := g.Join("one"); err == nil {
+		t.Error("expected duplicate member error")
+	}
+	owners := make(map[string]*Member)
+	seen := make(map[Timestamp]struct{})
+	for len(seen) < 12 {
+		for _, m := range members {
+			select {
+			case env := <-m.Channel():
+				id := env.Event.EntityID()
+				if owner, ok := owners[id]; ok && owner != m {
+					t.Errorf("entity %s delivered to %s and %s", id, owner.ID(), m.ID())
+				}
+				owners[id] = m
+				if _, ok := seen[env.Stamp]; ok {
+					t.Errorf("event %s delivered twice", env.Stamp)
+				}
+				seen[env.Stamp] = struct{}{}
+				if err := m.Ack(env); err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	cursor, err := dispatcher.Cursor(groupCursor("workers"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if cursor.Wall != int64(12*time.Second) {
+		t.Errorf("unexpected group position %s", cursor)
+	}
+}
+
+func TestGroupEntityOrdering(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	g, err := dispatcher.NewGroup(context.Background(), "workers", SubscriptionFilter{EntityID: "a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer g.Close()
+	m, _ := g.Join("one")
+	var last Timestamp
+	for i := 0; i < 4; i++ {
+		env := next(t, m)
+		if !last.Less(env.Stamp) {
+			t.Errorf("expected %s after %s", env.Stamp, last)
+		}
+		last = env.Stamp
+		// The next event of the entity is held back until this one is acknowledged
+		nothing(t, m)
+		if err := m.Ack(env); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if err := m.Ack(env); err != ErrNotDelivered {
+			t.Error("expected not delivered error")
+		}
+	}
+}
+
+func TestGroupRedelivery(t *testing.T) {
+	dispatcher, clk := setupEvents(t)
+	g, err := dispatcher.NewGroup(context.Background(), "workers", SubscriptionFilter{EntityID: "a"}, WithAckTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer g.Close()
+	m, _ := g.Join("one")
+	first := next(t, m)
+	clk.Advance(time.Minute)
+	if again := next(t, m); again.Stamp != first.Stamp {
+		t.Errorf("expected redelivery of %s, got %s", first.Stamp, again.Stamp)
+	}
+	if err := m.Ack(first); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestGroupRedeliveryDeadline(t *testing.T) {
+	dispatcher, clk := setupEvents(t)
+	g, err := dispatcher.NewGroup(context.Background(), "workers", SubscriptionFilter{EntityID: "a"}, WithAckTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer g.Close()
+	m, _ := g.Join("one")
+	first := next(t, m)
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+	if err := m.Ack(first); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	second := next(t, m)
+	// The second event is redelivered a minute after its own delivery, not on the next fixed tick
+	clk.Advance(30 * time.Second)
+	nothing(t, m)
+	clk.Advance(30 * time.Second)
+	if again := next(t, m); again.Stamp != second.Stamp {
+		t.Errorf("expected redelivery of %s, got %s", second.Stamp, again.Stamp)
+	}
+}
+
+func TestGroupLeave(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	g, err := dispatcher.NewGroup(context.Background(), "workers", SubscriptionFilter{EntityID: "b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	one, _ := g.Join("one")
+	first := next(t, one)
+	two, _ := g.Join("two")
+	one.Leave()
+	for range one.Channel() {
+		// Drain until closed
+	}
+	if again := next(t, two); again.Stamp != first.Stamp {
+		t.Errorf("expected redelivery of %s, got %s", first.Stamp, again.Stamp)
+	}
+	if err := one.Ack(first); err != ErrNotDelivered {
+		t.Error("expected not delivered error for departed member")
+	}
+	if err := g.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, ok := <-two.Channel(); ok {
+		t.Error("expected member channel to be closed")
+	}
+	if _, err := g.Join("three"); err != ErrGroupClosed {
+		t.Error("expected group closed error")
+	}
+}
@@ -0,0 +1,191 @@
+package eventstore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/textileio/go-eventstore/clock"
+)
+
+// DefaultMaxOffset is the maximum clock offset tolerated between nodes by default.
+const DefaultMaxOffset = 500 * time.Millisecond
+
+// ErrClockOffset is returned when a remote timestamp is too far ahead of the local physical clock.
+var ErrClockOffset = errors.New("remote clock offset exceeds maximum")
+
+// ErrStampConflict is returned when ingesting an event whose stamp and key are already taken by a
+// different event, e.g., because two peers share a node ID.
+var ErrStampConflict = errors.New("conflicting event with the same stamp")
+
+// ErrInvalidTimestamp is returned when parsing a malformed timestamp.
+var ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+// Timestamp is a hybrid logical clock timestamp. Wall is the physical component in nanoseconds
+// since the Unix epoch, and Logical orders timestamps sharing the same physical component. Node
+// identifies the clock that issued the timestamp: it breaks ties between clocks that issue the same
+// (Wall, Logical) pair, so that timestamps from different nodes never collide.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+	Node    uint64
+}
+
+// Less reports whether t happened before u. Concurrent timestamps with the same (Wall, Logical) pair
+// are ordered by Node.
+func (t Timestamp) Less(u Timestamp) bool {
+	if t.Wall != u.Wall {
+		return t.Wall < u.Wall
+	}
+	if t.Logical != u.Logical {
+		return t.Logical < u.Logical
+	}
+	return t.Node < u.Node
+}
+
+// IsZero reports whether t is the zero timestamp.
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Time returns the physical component of t.
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, t.Wall)
+}
+
+// next returns the smallest timestamp after t.
+func (t Timestamp) next() Timestamp {
+	switch {
+	case t.Node < math.MaxUint64:
+		return Timestamp{Wall: t.Wall, Logical: t.Logical, Node: t.Node + 1}
+	case t.Logical < math.MaxUint32:
+		return Timestamp{Wall: t.Wall, Logical: t.Logical + 1}
+	default:
+		return Timestamp{Wall: t.Wall + 1}
+	}
+}
+
+// tick returns the smallest (Wall, Logical) pair after that of t, on node.
+func (t Timestamp) tick(node uint64) Timestamp {
+	if t.Logical == math.MaxUint32 {
+		return Timestamp{Wall: t.Wall + 1, Node: node}
+	}
+	return Timestamp{Wall: t.Wall, Logical: t.Logical + 1, Node: node}
+}
+
+// String formats t as <wall>.<logical>, followed by .<node> for timestamps with a non-zero Node.
+func (t Timestamp) String() string {
+	if t.Node == 0 {
+		return fmt.Sprintf("%d.%d", t.Wall, t.Logical)
+	}
+	return fmt.Sprintf("%d.%d.%d", t.Wall, t.Logical, t.Node)
+}
+
+// ParseTimestamp parses a timestamp formatted by String.
+func ParseTimestamp(s string) (Timestamp, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 && len(parts) != 3 {
+		return Timestamp{}, fmt.Errorf("%w: `%s`", ErrInvalidTimestamp, s)
+	}
+	w, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("%w: `%s`", ErrInvalidTimestamp, s)
+	}
+	l, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("%w: `%s`", ErrInvalidTimestamp, s)
+	}
+	var n uint64
+	if len(parts) == 3 {
+		if n, err = strconv.ParseUint(parts[2], 10, 64); err != nil {
+			return Timestamp{}, fmt.Errorf("%w: `%s`", ErrInvalidTimestamp, s)
+		}
+	}
+	return Timestamp{Wall: w, Logical: uint32(l), Node: n}, nil
+}
+
+// HLC is a hybrid logical clock, as described in "Logical Physical Clocks and Consistent Snapshots
+// in Globally Distributed Databases" (Kulkarni et al.). Timestamps it produces never go backwards,
+// stay close to physical time, and respect causality across nodes that exchange timestamps
+// through Update. Every timestamp it issues carries its node ID, so clocks with distinct node IDs
+// never issue the same timestamp.
+type HLC struct {
+	lock      sync.Mutex
+	last      Timestamp
+	node      uint64
+	maxOffset time.Duration
+	clock     clock.Clock
+}
+
+// NewHLC creates a hybrid logical clock for node, whose physical component is read from c (the system
+// clock if nil). Remote timestamps more than maxOffset ahead of the physical clock are rejected by
+// Update. A zero maxOffset disables the check.
+func NewHLC(c clock.Clock, node uint64, maxOffset time.Duration) *HLC {
+	if c == nil {
+		c = clock.Real
+	}
+	return &HLC{
+		node:      node,
+		maxOffset: maxOffset,
+		clock:     c,
+	}
+}
+
+// Node returns the node ID stamped on every timestamp issued by the clock.
+func (c *HLC) Node() uint64 {
+	return c.node
+}
+
+// Now returns a new timestamp, strictly greater than any timestamp previously returned or observed.
+func (c *HLC) Now() Timestamp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pt := c.clock.Now().UnixNano()
+	if pt > c.last.Wall {
+		c.last = Timestamp{Wall: pt, Node: c.node}
+	} else {
+		c.last = c.last.tick(c.node)
+	}
+	return c.last
+}
+
+// Update merges a timestamp received from a remote node into the clock, and returns a new local
+// timestamp that is strictly greater than both remote and any previous local timestamp.
+func (c *HLC) Update(remote Timestamp) (Timestamp, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pt := c.clock.Now().UnixNano()
+	if c.maxOffset > 0 && remote.Wall-pt > int64(c.maxOffset) {
+		return Timestamp{}, fmt.Errorf("%w: %s ahead", ErrClockOffset, time.Duration(remote.Wall-pt))
+	}
+	switch {
+	case pt > c.last.Wall && pt > remote.Wall:
+		c.last = Timestamp{Wall: pt, Node: c.node}
+	case remote.Wall > c.last.Wall,
+		remote.Wall == c.last.Wall && remote.Logical > c.last.Logical:
+		c.last = remote.tick(c.node)
+	default:
+		c.last = c.last.tick(c.node)
+	}
+	return c.last, nil
+}
+
+// Last returns the most recent timestamp issued or observed by the clock.
+func (c *HLC) Last() Timestamp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.last
+}
+
+// observe advances the clock to at least ts without issuing a new timestamp.
+func (c *HLC) observe(ts Timestamp) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.last.Less(ts) {
+		c.last = ts
+	}
+}
@@ -0,0 +1,97 @@
+package eventstore
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/clock"
+)
+
+func TestHLCNow(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 100))
+	c := NewHLC(clk, 0, 0)
+	if ts := c.Now(); ts != (Timestamp{Wall: 100}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	if ts := c.Now(); ts != (Timestamp{Wall: 100, Logical: 1}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	// Physical clock goes backwards
+	clk.Set(time.Unix(0, 50))
+	if ts := c.Now(); ts != (Timestamp{Wall: 100, Logical: 2}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	clk.Set(time.Unix(0, 200))
+	if ts := c.Now(); ts != (Timestamp{Wall: 200}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+}
+
+func TestHLCUpdate(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 100))
+	c := NewHLC(clk, 1, 50)
+	ts, err := c.Update(Timestamp{Wall: 120, Logical: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ts != (Timestamp{Wall: 120, Logical: 4, Node: 1}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	// Remote is behind the local clock
+	if ts, _ = c.Update(Timestamp{Wall: 10}); ts != (Timestamp{Wall: 120, Logical: 5, Node: 1}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	// Remote has the same wall time and a greater logical component
+	if ts, _ = c.Update(Timestamp{Wall: 120, Logical: 9}); ts != (Timestamp{Wall: 120, Logical: 10, Node: 1}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	if _, err := c.Update(Timestamp{Wall: 151}); !errors.Is(err, ErrClockOffset) {
+		t.Error("expected clock offset error")
+	}
+	if c.Last() != (Timestamp{Wall: 120, Logical: 10, Node: 1}) {
+		t.Error("rejected update should not advance the clock")
+	}
+	clk.Set(time.Unix(0, 300))
+	if ts, _ = c.Update(Timestamp{Wall: 250}); ts != (Timestamp{Wall: 300, Node: 1}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+}
+
+func TestHLCNodes(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 100))
+	a, b := NewHLC(clk, 1, 0), NewHLC(clk, 2, 0)
+	ta, tb := a.Now(), b.Now()
+	if ta == tb {
+		t.Fatalf("expected distinct timestamps, got %s twice", ta)
+	}
+	if !ta.Less(tb) || tb.Less(ta) {
+		t.Errorf("expected %s to be ordered before %s", ta, tb)
+	}
+	// Merging a concurrent remote timestamp moves past it, on the local node
+	ts, err := a.Update(tb)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ts != (Timestamp{Wall: 100, Logical: 1, Node: 1}) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, ts := range []Timestamp{{}, {Wall: 120, Logical: 3}, {Wall: -5, Logical: math.MaxUint32, Node: math.MaxUint64}} {
+		parsed, err := ParseTimestamp(ts.String())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if parsed != ts {
+			t.Errorf("expected %s, got %s", ts, parsed)
+		}
+	}
+	for _, s := range []string{"", "120", "a.3", "120.-1", "120.4294967296", "120.3.x", "120.3.4.5"} {
+		if _, err := ParseTimestamp(s); !errors.Is(err, ErrInvalidTimestamp) {
+			t.Errorf("expected invalid timestamp for `%s`, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package eventstore
+
+import (
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Secondary indexes
+//
+// Alongside its primary key, every event is written under one key per secondary index, in the same
+// transaction:
+//
+//     /index/<version>/type/<type>/<time>/<seq>/<entity-id>
+//     /index/<version>/entity/<entity-id>/<time>/<seq>/<type>
+//
+// Segments are encoded exactly as in the primary key (see EventKey), so each index key holds
+// everything needed to rebuild the primary key, and entries under a given type or entity sort in
+// the same causal order as the primary keys. Index entries have empty values.
+
+// indexNamespace is the root of all secondary index keys.
+const indexNamespace = "index"
+
+const (
+	typeIndex   = "type"
+	entityIndex = "entity"
+)
+
+// FilterType matches events of the given type. Dispatcher.Query serves it from the type index.
+type FilterType struct {
+	Type string
+}
+
+// Filter returns whether the entry is an event of type f.Type.
+func (f FilterType) Filter(e query.Entry) bool {
+	k, err := ParseEventKey(datastore.NewKey(e.Key))
+	return err == nil && k.Type == f.Type
+}
+
+// FilterEntity matches events of the given entity. Dispatcher.Query serves it from the entity index.
+type FilterEntity struct {
+	EntityID string
+}
+
+// Filter returns whether the entry is an event of entity f.EntityID.
+func (f FilterEntity) Filter(e query.Entry) bool {
+	k, err := ParseEventKey(datastore.NewKey(e.Key))
+	return err == nil && k.EntityID == f.EntityID
+}
+
+// indexPrefix returns the key under which all index entries for value are stored.
+func indexPrefix(index, value string) datastore.Key {
+	return datastore.NewKey(indexNamespace).ChildString(KeyVersion).
+		ChildString(index).ChildString(escapeSegment(value))
+}
+
+// indexKeys returns the secondary index keys for k.
+func indexKeys(k EventKey) []datastore.Key {
+	stamp := []string{encodeTime(k.Stamp.Wall), encodeSeq(k.Stamp)}
+	return []datastore.Key{
+		indexPrefix(typeIndex, k.Type).ChildString(stamp[0]).ChildString(stamp[1]).
+			ChildString(escapeSegment(k.EntityID)),
+		indexPrefix(entityIndex, k.EntityID).ChildString(stamp[0]).ChildString(stamp[1]).
+			ChildString(escapeSegment(k.Type)),
+	}
+}
+
+// parseIndexKey rebuilds the primary key referenced by an index key.
+func parseIndexKey(key datastore.Key) (EventKey, error) {
+	parts := key.List()
+	if len(parts) != 7 || parts[0] != indexNamespace || parts[1] != KeyVersion {
+		return EventKey{}, ErrInvalidKey
+	}
+	var typ, id string
+	switch parts[2] {
+	case typeIndex:
+		typ, id = parts[3], parts[6]
+	case entityIndex:
+		id, typ = parts[3], parts[6]
+	default:
+		return EventKey{}, ErrInvalidKey
+	}
+	// Reassemble and parse as a primary key to reuse its validation
+	return ParseEventKey(eventsPrefix().ChildString(parts[4]).ChildString(parts[5]).
+		ChildString(id).ChildString(typ))
+}
+
+// indexFor returns the index prefix that can serve a query with the given filters, if any, along with
+// the filters left to apply. Entity filters are preferred over type filters, since entity streams are usually much smaller.
+func indexFor(filters []query.Filter) (datastore.Key, []query.Filter, bool) {
+	pick := -1
+	var prefix datastore.Key
+	for i, f := range filters {
+		if f, ok := f.(FilterEntity); ok {
+			pick, prefix = i, indexPrefix(entityIndex, f.EntityID)
+			break
+		}
+	}
+	if pick < 0 {
+		for i, f := range filters {
+			if f, ok := f.(FilterType); ok {
+				pick, prefix = i, indexPrefix(typeIndex, f.Type)
+				break
+			}
+		}
+	}
+	if pick < 0 {
+		return datastore.Key{}, filters, false
+	}
+	rest := make([]query.Filter, 0, len(filters)-1)
+	rest = append(rest, filters[:pick]...)
+	rest = append(rest, filters[pick+1:]...)
+	return prefix, rest, true
+}
@@ -0,0 +1,101 @@
+package eventstore
+
+import (
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+func TestIndexKeys(t *testing.T) {
+	k := EventKey{Stamp: Timestamp{Wall: -5, Logical: 2}, EntityID: "order/1", Type: "OrderPlaced"}
+	keys := indexKeys(k)
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 index keys, got %d", len(keys))
+	}
+	for _, key := range keys {
+		parsed, err := parseIndexKey(key)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", key, err.Error())
+		}
+		if parsed != k {
+			t.Errorf("expected %+v, got %+v", k, parsed)
+		}
+	}
+	if _, err := parseIndexKey(datastore.NewKey("/index/v1/blah/a/b/c/d")); err != ErrInvalidKey {
+		t.Error("expected invalid key error")
+	}
+}
+
+func TestIndexFor(t *testing.T) {
+	filters := []query.Filter{FilterType{Type: "a"}, FilterEntity{EntityID: "b"}}
+	prefix, rest, ok := indexFor(filters)
+	if !ok || prefix != indexPrefix(entityIndex, "b") {
+		t.Errorf("expected entity index, got %s", prefix)
+	}
+	if len(rest) != 1 || rest[0] != filters[0] {
+		t.Error("expected type filter to remain")
+	}
+	if _, _, ok := indexFor([]query.Filter{query.FilterKeyPrefix{Prefix: "/"}}); ok {
+		t.Error("expected no index")
+	}
+}
+
+func TestQueryIndexes(t *testing.T) {
+	eventstore := NewTxMapDatastore()
+	dispatcher := NewDispatcher(eventstore)
+	for _, id := range []string{"a", "b", "c"} {
+		for _, kind := range []string{"Created", "Updated"} {
+			event := &testEvent{ID: id, Kind: kind, Timestamp: time.Now()}
+			if err := dispatcher.Dispatch(event); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+		}
+	}
+	// An event written without index entries is only visible to full scans
+	orphan := &Envelope{Stamp: dispatcher.Clock().Now(), Event: &testEvent{ID: "a", Kind: "Created"}}
+	b, _ := orphan.MarshalBinary()
+	if err := eventstore.Put(orphan.Key().Key(), b); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	all, err := dispatcher.Query(query.Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(all) != 7 {
+		t.Errorf("expected 7 results, got %d", len(all))
+	}
+	results, err := dispatcher.Query(query.Query{
+		Filters: []query.Filter{FilterType{Type: "Updated"}},
+		Orders:  []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for _, res := range results {
+		env := &Envelope{}
+		if err := env.UnmarshalBinary(res.Value); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if env.Event.Type() != "Updated" || env.Key().Key().String() != res.Key {
+			t.Errorf("unexpected result %s", res.Key)
+		}
+	}
+	results, err = dispatcher.Query(query.Query{
+		Filters:  []query.Filter{FilterEntity{EntityID: "a"}, FilterType{Type: "Created"}},
+		KeysOnly: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].Value != nil {
+		t.Error("expected keys only")
+	}
+}
@@ -0,0 +1,197 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// StreamInfo summarizes an entity stream.
+type StreamInfo struct {
+	EntityID string
+	Version  int
+	Last     Timestamp // stamp of the latest event
+}
+
+// Streams returns a summary of every entity stream in the store, ordered by entity ID. Versions are
+// counted from the entity index, regardless of the stored version keys (see Verify).
+func (d *Dispatcher) Streams(ctx context.Context) ([]StreamInfo, error) {
+	var streams []StreamInfo
+	err := d.scan(ctx, datastore.NewKey(indexNamespace).ChildString(KeyVersion).ChildString(entityIndex), true,
+		func(key datastore.Key, _ []byte) error {
+			k, err := parseIndexKey(key)
+			if err != nil {
+				return fmt.Errorf("%w: `%s`", err, key)
+			}
+			if n := len(streams); n > 0 && streams[n-1].EntityID == k.EntityID {
+				// Entries of an entity are in causal order
+				streams[n-1].Version++
+				streams[n-1].Last = k.Stamp
+				return nil
+			}
+			streams = append(streams, StreamInfo{EntityID: k.EntityID, Version: 1, Last: k.Stamp})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].EntityID < streams[j].EntityID
+	})
+	return streams, nil
+}
+
+// CountTypes returns the number of stored events of each type.
+func (d *Dispatcher) CountTypes(ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := d.scan(ctx, datastore.NewKey(indexNamespace).ChildString(KeyVersion).ChildString(typeIndex), true,
+		func(key datastore.Key, _ []byte) error {
+			k, err := parseIndexKey(key)
+			if err != nil {
+				return fmt.Errorf("%w: `%s`", err, key)
+			}
+			counts[k.Type]++
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// Inconsistency is an integrity problem found by Verify.
+type Inconsistency struct {
+	Key     string
+	Problem string
+}
+
+func (i Inconsistency) String() string {
+	return fmt.Sprintf("%s: %s", i.Key, i.Problem)
+}
+
+// Verify checks the integrity of the store, and returns the inconsistencies found: primary keys that do
+// not follow the key encoding, events that do not decode or do not match their key, missing or dangling
+// index and outbox entries, and stored versions and event count that differ from the number of events.
+// The returned error is only set if the store cannot be read.
+func (d *Dispatcher) Verify(ctx context.Context) ([]Inconsistency, error) {
+	var found []Inconsistency
+	report := func(key datastore.Key, format string, args ...interface{}) {
+		found = append(found, Inconsistency{Key: key.String(), Problem: fmt.Sprintf(format, args...)})
+	}
+	versions := make(map[string]int)
+	total := 0
+	err := d.scan(ctx, eventsPrefix(), false, func(key datastore.Key, value []byte) error {
+		k, err := ParseEventKey(key)
+		if err != nil {
+			report(key, "invalid key: %s", err)
+			return nil
+		}
+		total++
+		env := &Envelope{}
+		if err := env.UnmarshalBinary(value); err != nil {
+			report(key, "invalid encoding: %s", err)
+			return nil
+		}
+		if env.Key() != k {
+			report(key, "event does not match its key: stamped %s, entity `%s`, type `%s`",
+				env.Stamp, env.Event.EntityID(), env.Event.Type())
+		}
+		for _, index := range indexKeys(k) {
+			exists, err := d.store.Has(index)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				report(key, "missing index entry `%s`", index)
+			}
+		}
+		versions[k.EntityID]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Index and outbox entries must reference an existing event
+	dangling := func(parse func(datastore.Key) (EventKey, error)) func(datastore.Key, []byte) error {
+		return func(key datastore.Key, _ []byte) error {
+			k, err := parse(key)
+			if err != nil {
+				report(key, "invalid key: %s", err)
+				return nil
+			}
+			exists, err := d.store.Has(k.Key())
+			if err != nil {
+				return err
+			}
+			if !exists {
+				report(key, "references missing event `%s`", k.Key())
+			}
+			return nil
+		}
+	}
+	if err := d.scan(ctx, datastore.NewKey(indexNamespace), true, dangling(parseIndexKey)); err != nil {
+		return nil, err
+	}
+	if err := d.scan(ctx, datastore.NewKey(outboxNamespace), true, dangling(parseOutboxKey)); err != nil {
+		return nil, err
+	}
+	err = d.scan(ctx, datastore.NewKey(versionsNamespace), false, func(key datastore.Key, value []byte) error {
+		parts := key.List()
+		if len(parts) != 3 || parts[1] != KeyVersion {
+			report(key, "invalid key")
+			return nil
+		}
+		id, err := unescapeSegment(parts[2])
+		if err != nil {
+			report(key, "invalid key: %s", err)
+			return nil
+		}
+		if len(value) != 8 {
+			report(key, "invalid encoding")
+			return nil
+		}
+		if v := int(binary.BigEndian.Uint64(value)); v != versions[id] {
+			report(key, "version %d of `%s` does not match its %d events", v, id, versions[id])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.store.Get(countKey())
+	switch {
+	case err == datastore.ErrNotFound:
+	case err != nil:
+		return nil, err
+	case len(b) != 8:
+		report(countKey(), "invalid encoding")
+	case int(binary.BigEndian.Uint64(b)) != total:
+		report(countKey(), "event count %d does not match the %d events", binary.BigEndian.Uint64(b), total)
+	}
+	return found, nil
+}
+
+// scan calls fn with every entry under prefix, in key order, until fn returns an error. Values are not
+// read if keysOnly is set.
+func (d *Dispatcher) scan(ctx context.Context, prefix datastore.Key, keysOnly bool, fn func(datastore.Key, []byte) error) error {
+	result, err := d.store.Query(query.Query{
+		Prefix:   prefix.String() + "/",
+		Orders:   []query.Order{query.OrderByKey{}},
+		KeysOnly: keysOnly,
+	})
+	if err != nil {
+		return err
+	}
+	it := newIterator(ctx, result)
+	defer it.Close()
+	for it.Next() {
+		if err := fn(datastore.NewKey(it.Entry().Key), it.Entry().Value); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,100 @@
+package eventstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreams(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	streams, err := dispatcher.Streams(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(streams) != 3 {
+		t.Fatalf("expected 3 streams, got %d", len(streams))
+	}
+	for i, s := range streams {
+		if s.EntityID != string(rune('a'+i)) || s.Version != 4 {
+			t.Errorf("unexpected stream %v", s)
+		}
+		// The last of the 12 events, one second apart, belong to a, b and c in turn
+		if s.Last.Wall != int64(10+i)*int64(time.Second) {
+			t.Errorf("unexpected last stamp %s for `%s`", s.Last, s.EntityID)
+		}
+	}
+}
+
+func TestCountTypes(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	counts, err := dispatcher.CountTypes(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(counts) != 2 || counts["Created"] != 6 || counts["Updated"] != 6 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	ctx := context.Background()
+	found, err := dispatcher.Verify(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(found) != 0 {
+		t.Fatalf("expected a consistent store, got %v", found)
+	}
+	envs, err := dispatcher.Events().ForEntity("a").Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	store := dispatcher.Store()
+	// Corrupt the store in every way Verify checks
+	if err := store.Delete(indexKeys(envs[0].Key())[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := store.Delete(envs[1].Key().Key()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := store.Put(envs[2].Key().Key(), []byte("garbage")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := store.Put(eventsPrefix().ChildString("bad"), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := store.Put(versionKey("b"), encodeVersion(7)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	found, err = dispatcher.Verify(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := []string{
+		"missing index entry",      // of the first event of a
+		"references missing event", // entity index of the deleted event
+		"references missing event", // type index of the deleted event
+		"invalid encoding",         // garbage event
+		"invalid key",              // bad key
+		"version 4 of `a`",         // a lost two events
+		"version 7 of `b`",         // tampered version
+		"event count 12",           // the deleted event is still counted
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d inconsistencies, got %v", len(expected), found)
+	}
+	for _, problem := range expected {
+		n := 0
+		for _, f := range found {
+			if strings.Contains(f.Problem, problem) {
+				n++
+			}
+		}
+		if n == 0 {
+			t.Errorf("expected an inconsistency like `%s`, got %v", problem, found)
+		}
+	}
+}
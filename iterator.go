@@ -0,0 +1,202 @@
+package eventstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-datastore/query"
+)
+
+// DefaultPageSize is the number of events an EventIterator decodes from the store at a time.
+const DefaultPageSize = 100
+
+// Iterator streams the entries of a query result one at a time, instead of loading them all in memory:
+//
+//     it, err := d.QueryIter(ctx, q)
+//     if err != nil { ... }
+//     defer it.Close()
+//     for it.Next() {
+//         entry := it.Entry()
+//     }
+//     if err := it.Err(); err != nil { ... }
+//
+// Iteration stops when the context is done, in which case Err returns the context's error.
+type Iterator struct {
+	ctx     context.Context
+	results query.Results
+	entry   query.Entry
+	err     error
+	done    bool
+}
+
+func newIterator(ctx context.Context, results query.Results) *Iterator {
+	return &Iterator{ctx: ctx, results: results}
+}
+
+// Next advances the iterator, and reports whether there is an entry to read.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	res, ok := it.results.NextSync()
+	if !ok {
+		it.Close()
+		return false
+	}
+	if res.Error != nil {
+		it.err = res.Error
+		it.Close()
+		return false
+	}
+	it.entry = res.Entry
+	return true
+}
+
+// Entry returns the current entry.
+func (it *Iterator) Entry() query.Entry {
+	return it.entry
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the underlying query results. It is safe to call more than once.
+func (it *Iterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.done = true
+	return it.results.Close()
+}
+
+// EventIterator streams the decoded events matching an EventQuery. It keeps a single query open on the
+// store, and decodes events from it a page at a time, so that memory use is bounded by the page size
+// regardless of the number of events.
+//
+// Cursor returns the Stamp of the last event read, which can later be passed to EventQuery.After to
+// resume iteration.
+type EventIterator struct {
+	ctx      context.Context
+	q        EventQuery
+	pageSize int
+	results  query.Results
+	indexed  bool
+	page     []*Envelope
+	pos      int
+	env      *Envelope
+	cursor   Timestamp
+	err      error
+	done     bool
+	locker   sync.Locker // if set, held while fetching each page
+}
+
+// Iter returns an iterator over the events matching q.
+func (q EventQuery) Iter(ctx context.Context) *EventIterator {
+	it := &EventIterator{
+		ctx:      ctx,
+		q:        q,
+		pageSize: q.pageSize,
+	}
+	if it.pageSize <= 0 {
+		it.pageSize = DefaultPageSize
+	}
+	return it
+}
+
+// PageSize sets the number of events decoded at a time by Iter. Defaults to DefaultPageSize.
+func (q EventQuery) PageSize(n int) EventQuery {
+	q.pageSize = n
+	return q
+}
+
+// Next advances the iterator, and reports whether there is an event to read.
+func (it *EventIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	if it.pos >= len(it.page) && !it.fetch() {
+		it.Close()
+		return false
+	}
+	it.env = it.page[it.pos]
+	it.page[it.pos] = nil
+	it.pos++
+	it.cursor = it.env.Stamp
+	return true
+}
+
+// fetch decodes the next page of events from the query results, opening them on first use, and
+// reports whether the page is non-empty.
+func (it *EventIterator) fetch() bool {
+	if it.locker != nil {
+		it.locker.Lock()
+		defer it.locker.Unlock()
+	}
+	if it.results == nil {
+		s := it.q.compile()
+		results, err := it.q.d.open(s)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.results, it.indexed = results, s.indexed
+	}
+	it.page, it.pos = it.page[:0], 0
+	for len(it.page) < it.pageSize {
+		res, ok := it.results.NextSync()
+		if !ok {
+			break
+		}
+		if res.Error != nil {
+			it.err = res.Error
+			return false
+		}
+		env, err := it.q.d.decode(res.Entry, it.indexed)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = append(it.page, env)
+	}
+	return len(it.page) > 0
+}
+
+// Event returns the current event.
+func (it *EventIterator) Event() *Envelope {
+	return it.env
+}
+
+// Cursor returns the Stamp of the last event read, or the zero Timestamp if none has been read yet.
+func (it *EventIterator) Cursor() Timestamp {
+	return it.cursor
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *EventIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration, and releases the underlying query results. It is safe to call more than once.
+func (it *EventIterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.done = true
+	it.page = nil
+	if it.results == nil {
+		return nil
+	}
+	return it.results.Close()
+}
@@ -0,0 +1,150 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+func collect(t *testing.T, it *EventIterator) []Timestamp {
+	var stamps []Timestamp
+	for it.Next() {
+		stamps = append(stamps, it.Event().Stamp)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return stamps
+}
+
+func TestEventIterator(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	queries := []EventQuery{
+		dispatcher.Events(),
+		dispatcher.Events().Reverse(),
+		dispatcher.Events().OfType("Created").Limit(4),
+		dispatcher.Events().ForEntity("c").Reverse().Limit(3),
+	}
+	for _, q := range queries {
+		expected, err := q.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		for _, size := range []int{1, 2, 5, 100} {
+			stamps := collect(t, q.PageSize(size).Iter(context.Background()))
+			if len(stamps) != len(expected) {
+				t.Fatalf("page size %d: expected %d events, got %d", size, len(expected), len(stamps))
+			}
+			for i, env := range expected {
+				if stamps[i] != env.Stamp {
+					t.Errorf("page size %d: unexpected stamp %s at position %d", size, stamps[i], i)
+				}
+			}
+		}
+	}
+}
+
+// countingStore counts the queries run against a datastore.
+type countingStore struct {
+	datastore.TxnDatastore
+	queries int
+}
+
+func (c *countingStore) Query(q query.Query) (query.Results, error) {
+	c.queries++
+	return c.TxnDatastore.Query(q)
+}
+
+func TestEventIteratorSingleQuery(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	store := &countingStore{TxnDatastore: dispatcher.store}
+	dispatcher.store = store
+	for _, q := range []EventQuery{dispatcher.Events(), dispatcher.Events().OfType("Created")} {
+		store.queries = 0
+		it := q.PageSize(2).Iter(context.Background())
+		if n := len(collect(t, it)); n == 0 {
+			t.Fatal("expected events")
+		}
+		if store.queries != 1 {
+			t.Errorf("expected 1 query across pages, got %d", store.queries)
+		}
+	}
+}
+
+func TestEventIteratorResume(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	it := dispatcher.Events().PageSize(2).Iter(context.Background())
+	for i := 0; i < 5; i++ {
+		if !it.Next() {
+			t.Fatal("expected more events")
+		}
+	}
+	it.Close()
+	if it.Next() {
+		t.Error("closed iterator should not advance")
+	}
+	rest := collect(t, dispatcher.Events().After(it.Cursor()).Iter(context.Background()))
+	if len(rest) != 7 {
+		t.Errorf("expected 7 remaining events, got %d", len(rest))
+	}
+}
+
+func TestEventIteratorCancel(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	it := dispatcher.Events().PageSize(2).Iter(ctx)
+	if !it.Next() {
+		t.Fatal("expected an event")
+	}
+	cancel()
+	if it.Next() {
+		t.Error("cancelled iterator should not advance")
+	}
+	if it.Err() != context.Canceled {
+		t.Error("expected context cancelled error")
+	}
+}
+
+func TestQueryIter(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	it, err := dispatcher.QueryIter(context.Background(), query.Query{
+		Filters: []query.Filter{FilterEntity{EntityID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		if _, err := ParseEventKey(datastore.NewKey(it.Entry().Key)); err != nil {
+			t.Errorf("unexpected key %s", it.Entry().Key)
+		}
+		n++
+	}
+	if it.Err() != nil || n != 4 {
+		t.Errorf("expected 4 entries, got %d", n)
+	}
+}
+
+func TestStoredModelQueryIter(t *testing.T) {
+	model := NewMapModel()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := model.Reduce(&testEvent{ID: id, Kind: "Created"}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	it, err := model.QueryIter(ctx, query.Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !it.Next() {
+		t.Fatal("expected an entry")
+	}
+	cancel()
+	if it.Next() || it.Err() != context.Canceled {
+		t.Error("expected iteration to stop on cancellation")
+	}
+}
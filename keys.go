@@ -0,0 +1,154 @@
+package eventstore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	datastore "github.com/ipfs/go-datastore"
+)
+
+// Event keys
+//
+// Every event is stored under a primary key of the form:
+//
+//     /events/<version>/<time>/<seq>/<entity-id>/<type>
+//
+// where:
+//
+//   - <version> is the key encoding version (currently KeyVersion, "v1").
+//   - <time> is the wall component of the event's hybrid logical clock Timestamp, in
+//     nanoseconds, encoded as 16 lower-case hex digits with the sign bit flipped, so that
+//     negative timestamps sort before positive ones.
+//   - <seq> is the logical component of the Timestamp, encoded as 8 hex digits, followed by
+//     its node ID, encoded as 16 hex digits. It orders events sharing the same wall time, and
+//     since the node ID identifies the issuing clock, <time>/<seq> never repeats across nodes.
+//   - <entity-id> and <type> are the event's EntityID and Type, escaped so that they form a
+//     single, non-empty key segment (see escapeSegment).
+//
+// Fixed-width, order-preserving encodings mean that sorting keys lexicographically sorts
+// events in causal (hybrid logical clock) order.
+
+// KeyVersion is the version of the event key encoding written by this package.
+const KeyVersion = "v1"
+
+// eventsNamespace is the root of all event keys.
+const eventsNamespace = "events"
+
+var (
+	// ErrInvalidKey is returned when a key does not follow the event key encoding.
+	ErrInvalidKey = errors.New("invalid event key")
+	// ErrInvalidEvent is returned when an event cannot be encoded into a key.
+	ErrInvalidEvent = errors.New("invalid event")
+)
+
+// EventKey holds the decoded components of an event's primary key.
+type EventKey struct {
+	Stamp    Timestamp
+	EntityID string
+	Type     string
+}
+
+// Key returns the datastore key for k.
+func (k EventKey) Key() datastore.Key {
+	return stampPrefix(k.Stamp).
+		ChildString(escapeSegment(k.EntityID)).
+		ChildString(escapeSegment(k.Type))
+}
+
+// ParseEventKey decodes a primary event key.
+func ParseEventKey(key datastore.Key) (EventKey, error) {
+	parts := key.List()
+	if len(parts) != 6 || parts[0] != eventsNamespace || parts[1] != KeyVersion {
+		return EventKey{}, ErrInvalidKey
+	}
+	t, err := decodeTime(parts[2])
+	if err != nil {
+		return EventKey{}, err
+	}
+	seq, node, err := decodeSeq(parts[3])
+	if err != nil {
+		return EventKey{}, err
+	}
+	id, err := unescapeSegment(parts[4])
+	if err != nil {
+		return EventKey{}, err
+	}
+	typ, err := unescapeSegment(parts[5])
+	if err != nil {
+		return EventKey{}, err
+	}
+	return EventKey{Stamp: Timestamp{Wall: t, Logical: seq, Node: node}, EntityID: id, Type: typ}, nil
+}
+
+// eventsPrefix returns the key under which all events of the current version are stored.
+func eventsPrefix() datastore.Key {
+	return datastore.NewKey(eventsNamespace).ChildString(KeyVersion)
+}
+
+// stampPrefix returns the key under which all events with timestamp ts are stored.
+func stampPrefix(ts Timestamp) datastore.Key {
+	return eventsPrefix().ChildString(encodeTime(ts.Wall)).ChildString(encodeSeq(ts))
+}
+
+func encodeTime(t int64) string {
+	// Flip the sign bit so that negative values sort before positive ones
+	return fmt.Sprintf("%016x", uint64(t)^(1<<63))
+}
+
+func decodeTime(s string) (int64, error) {
+	if len(s) != 16 {
+		return 0, ErrInvalidKey
+	}
+	u, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, ErrInvalidKey
+	}
+	return int64(u ^ (1 << 63)), nil
+}
+
+func encodeSeq(ts Timestamp) string {
+	return fmt.Sprintf("%08x%016x", ts.Logical, ts.Node)
+}
+
+func decodeSeq(s string) (uint32, uint64, error) {
+	if len(s) != 24 {
+		return 0, 0, ErrInvalidKey
+	}
+	seq, err := strconv.ParseUint(s[:8], 16, 32)
+	if err != nil {
+		return 0, 0, ErrInvalidKey
+	}
+	node, err := strconv.ParseUint(s[8:], 16, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidKey
+	}
+	return uint32(seq), node, nil
+}
+
+// escapeSegment percent-encodes every byte of s outside of [A-Za-z0-9_~-], which keeps
+// '/' and '.' out of the resulting key segment so datastore.Key cannot split or clean it.
+func escapeSegment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func unescapeSegment(s string) (string, error) {
+	u, err := url.PathUnescape(s)
+	if err != nil || u == "" {
+		return "", ErrInvalidKey
+	}
+	return u, nil
+}
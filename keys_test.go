@@ -0,0 +1,68 @@
+package eventstore
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+)
+
+func TestEventKeyRoundTrip(t *testing.T) {
+	keys := []EventKey{
+		{Stamp: Timestamp{Wall: 0, Logical: 0}, EntityID: "null", Type: "null"},
+		{Stamp: Timestamp{Wall: -42, Logical: 7, Node: 3}, EntityID: "a/b/../c", Type: "Order Placed"},
+		{Stamp: Timestamp{Wall: math.MaxInt64, Logical: math.MaxUint32, Node: math.MaxUint64}, EntityID: ".", Type: "%2F"},
+	}
+	for _, k := range keys {
+		parsed, err := ParseEventKey(k.Key())
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", k.Key(), err.Error())
+		}
+		if parsed != k {
+			t.Errorf("expected %+v, got %+v", k, parsed)
+		}
+		if len(k.Key().List()) != 6 {
+			t.Errorf("expected 6 key segments in %s", k.Key())
+		}
+	}
+}
+
+func TestEventKeyOrder(t *testing.T) {
+	expected := []EventKey{
+		{Stamp: Timestamp{Wall: math.MinInt64}, EntityID: "z", Type: "z"},
+		{Stamp: Timestamp{Wall: -1}, EntityID: "z", Type: "z"},
+		{Stamp: Timestamp{Wall: 0, Logical: 0}, EntityID: "z", Type: "z"},
+		{Stamp: Timestamp{Wall: 0, Logical: 1}, EntityID: "a", Type: "a"},
+		{Stamp: Timestamp{Wall: 0, Logical: 1, Node: 2}, EntityID: "a", Type: "a"},
+		{Stamp: Timestamp{Wall: 1}, EntityID: "a", Type: "a"},
+		{Stamp: Timestamp{Wall: math.MaxInt64}, EntityID: "a", Type: "a"},
+	}
+	var keys []string
+	for i := len(expected) - 1; i >= 0; i-- {
+		keys = append(keys, expected[i].Key().String())
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if k != expected[i].Key().String() {
+			t.Errorf("expected %s at position %d, got %s", expected[i].Key(), i, k)
+		}
+	}
+}
+
+func TestParseInvalidEventKey(t *testing.T) {
+	invalid := []string{
+		"/blah",
+		"/events/v0/8000000000000000/000000000000000000000000/null/null",
+		"/events/v1/800000000000000/000000000000000000000000/null/null",
+		"/events/v1/8000000000000000/00000000/null/null",
+		"/events/v1/8000000000000000/0000000g0000000000000000/null/null",
+		"/events/v1/8000000000000000/00000000000000000000000g/null/null",
+		"/events/v1/8000000000000000/000000000000000000000000/%zz/null",
+	}
+	for _, k := range invalid {
+		if _, err := ParseEventKey(datastore.NewKey(k)); err != ErrInvalidKey {
+			t.Errorf("expected invalid key error for %s", k)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package leveldb provides a LevelDB datastore for event stores, which extends that of
+// github.com/ipfs/go-ds-leveldb with range queries, so that event queries bounded by time or position
+// seek to their first key instead of scanning every key under their prefix:
+//
+//     store, err := leveldb.NewDatastore(path, nil)
+//     d := eventstore.NewDispatcher(store)
+package leveldb
+
+import (
+	dsleveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	eventstore "github.com/textileio/go-eventstore"
+)
+
+// Options configures the underlying LevelDB database.
+type Options = dsleveldb.Options
+
+// Datastore is a LevelDB datastore implementing eventstore.RangeQuerier.
+type Datastore struct {
+	*dsleveldb.Datastore
+}
+
+// NewDatastore opens the LevelDB database at path, or an in-memory database if path is empty.
+func NewDatastore(path string, opts *Options) (*Datastore, error) {
+	ds, err := dsleveldb.NewDatastore(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Datastore{Datastore: ds}, nil
+}
+
+// QueryRange is like Query, restricted to the keys at or after start, and before end. An empty end means
+// no upper bound.
+func (d *Datastore) QueryRange(q query.Query, start, end string) (query.Results, error) {
+	r := util.BytesPrefix([]byte(q.Prefix))
+	if start > string(r.Start) {
+		r.Start = []byte(start)
+	}
+	if end != "" && (r.Limit == nil || end < string(r.Limit)) {
+		r.Limit = []byte(end)
+	}
+	it := d.DB.NewIterator(r, nil)
+	// The iterator already restricts keys to the prefix, and orders them by key
+	naive := q
+	naive.Prefix = ""
+	next := it.Next
+	if len(q.Orders) > 0 {
+		switch q.Orders[0].(type) {
+		case query.OrderByKey, *query.OrderByKey:
+			naive.Orders = nil
+		case query.OrderByKeyDescending, *query.OrderByKeyDescending:
+			next = func() bool {
+				next = it.Prev
+				return it.Last()
+			}
+			naive.Orders = nil
+		}
+	}
+	results := query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			if !next() {
+				return query.Result{}, false
+			}
+			e := query.Entry{Key: string(it.Key())}
+			if !q.KeysOnly {
+				e.Value = append([]byte(nil), it.Value()...)
+			}
+			return query.Result{Entry: e}, true
+		},
+		Close: func() error {
+			it.Release()
+			return nil
+		},
+	})
+	return query.NaiveQueryApply(naive, results), nil
+}
+
+// Sanity check
+var _ eventstore.RangeQuerier = (*Datastore)(nil)
@@ -0,0 +1,77 @@
+package leveldb
+
+import (
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	eventstore "github.com/textileio/go-eventstore"
+	"github.com/textileio/go-eventstore/clock"
+)
+
+func TestQueryRange(t *testing.T) {
+	store, err := NewDatastore("", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer store.Close()
+	clk := clock.NewFake(time.Unix(0, 0))
+	d := eventstore.NewDispatcher(store, eventstore.WithClock(clk))
+	for i := 0; i < 10; i++ {
+		clk.Advance(time.Second)
+		typ := []string{"Created", "Updated"}[i%2]
+		if err := d.Dispatch(eventstore.NewEvent("a", typ, nil, nil, nil)); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	tests := []struct {
+		name  string
+		query eventstore.EventQuery
+		walls []int64 // expected stamps, in seconds
+	}{
+		{"all", d.Events().Limit(3), []int64{1, 2, 3}},
+		{"between", d.Events().Between(time.Unix(3, 0), time.Unix(6, 0)), []int64{3, 4, 5}},
+		{"reverse", d.Events().OfType("Updated").Between(time.Unix(3, 0), time.Unix(9, 0)).Reverse(), []int64{8, 6, 4}},
+		{"after", d.Events().ForEntity("a").After(eventstore.Timestamp{Wall: int64(8 * time.Second), Node: d.Clock().Node()}), []int64{9, 10}},
+		{"since", d.Events().Between(time.Unix(9, 0), time.Time{}).Reverse(), []int64{10, 9}},
+	}
+	for _, test := range tests {
+		envs, err := test.query.Run()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err.Error())
+		}
+		if len(envs) != len(test.walls) {
+			t.Errorf("%s: expected %d events, got %d", test.name, len(test.walls), len(envs))
+			continue
+		}
+		for i, env := range envs {
+			if env.Stamp.Wall != test.walls[i]*int64(time.Second) {
+				t.Errorf("%s: unexpected stamp %s at position %d", test.name, env.Stamp, i)
+			}
+		}
+	}
+}
+
+func TestQueryRangePrefix(t *testing.T) {
+	store, err := NewDatastore("", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer store.Close()
+	for _, k := range []string{"/a/1", "/a/2", "/a/3", "/b/1"} {
+		store.Put(datastore.NewKey(k), []byte(k))
+	}
+	// The range is clamped to the prefix
+	results, err := store.QueryRange(query.Query{Prefix: "/a/"}, "/a/2", "/b/2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(entries) != 2 || entries[0].Key != "/a/2" || string(entries[1].Value) != "/a/3" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
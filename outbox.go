@@ -0,0 +1,362 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
+)
+
+// Outbox
+//
+// With WithOutbox, every stored event is also written, in the same transaction, under:
+//
+//     /outbox/<version>/<time>/<seq>/<entity-id>/<type>
+//
+// with segments encoded as in the primary key, and an empty value. Outbox entries thus sort in causal
+// order, and each holds everything needed to rebuild the primary key of its event. A Relay deletes
+// entries once their events are published. Entries it gives up on are moved, in a single transaction, to:
+//
+//     /deadletters/<version>/<time>/<seq>/<entity-id>/<type>
+//
+// whose value is the error that made the Relay give up.
+
+// outboxNamespace is the root of outbox keys.
+const outboxNamespace = "outbox"
+
+// deadLettersNamespace is the root of dead letter keys.
+const deadLettersNamespace = "deadletters"
+
+// ErrPermanent is wrapped by Publisher errors that retrying cannot fix, e.g., an event rejected by the
+// broker. The Relay dead-letters such events instead of retrying them.
+var ErrPermanent = errors.New("permanent failure")
+
+// ErrEventMissing is recorded for outbox entries whose event cannot be found.
+var ErrEventMissing = errors.New("outbox event missing")
+
+const (
+	// DefaultRetryMin is the delay before a Relay first retries publishing an event.
+	DefaultRetryMin = 100 * time.Millisecond
+	// DefaultRetryMax is the longest delay between a Relay's attempts to publish an event.
+	DefaultRetryMax = 30 * time.Second
+	// DefaultMissingRetries is how many times a Relay retries reading the event of an outbox entry before
+	// dead-lettering the entry.
+	DefaultMissingRetries = 10
+)
+
+// Publisher publishes events to an external system, such as a message broker.
+type Publisher interface {
+	Publish(ctx context.Context, env *Envelope) error
+}
+
+// RelayOption configures a Relay.
+type RelayOption func(*Relay)
+
+// WithRetryBackoff sets the delays between a Relay's attempts to publish an event, which start at min
+// and double up to max. Defaults to DefaultRetryMin and DefaultRetryMax.
+func WithRetryBackoff(min, max time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.retryMin, r.retryMax = min, max
+	}
+}
+
+// WithMissingRetries sets how many times a Relay retries reading the event of an outbox entry, with the
+// same backoff as publishing, before dead-lettering the entry. Defaults to DefaultMissingRetries.
+func WithMissingRetries(n int) RelayOption {
+	return func(r *Relay) {
+		r.missingRetries = n
+	}
+}
+
+// Relay publishes the events in a Dispatcher's outbox (see WithOutbox), one at a time, in causal order.
+// An event that fails to publish is retried, with exponential backoff, until it succeeds, and later
+// events wait for it, so a Publisher sees events in order. Events that fail with ErrPermanent, or that
+// are still missing after the retries set with WithMissingRetries, are dead-lettered instead (see
+// DeadLetters). Delivery is at-least-once: an event published
+// right before a crash may be published again, so consumers should deduplicate, e.g., on the envelope's
+// Stamp and entity.
+type Relay struct {
+	d              *Dispatcher
+	publisher      Publisher
+	clock          clock.Clock
+	retryMin       time.Duration
+	retryMax       time.Duration
+	missingRetries int
+}
+
+// NewRelay creates a Relay publishing the outbox of d to publisher.
+func NewRelay(d *Dispatcher, publisher Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		d:              d,
+		publisher:      publisher,
+		clock:          d.clock.clock,
+		retryMin:       DefaultRetryMin,
+		retryMax:       DefaultRetryMax,
+		missingRetries: DefaultMissingRetries,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run publishes outbox events as they are stored, until ctx is done, in which case it returns nil, or
+// reading the outbox fails. A single Run per store must be active at a time.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.relay(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		select {
+		case <-r.d.outboxed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// relay publishes a page of outbox events, and returns how many were published.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	result, err := r.d.store.Query(query.Query{
+		Prefix:   outboxPrefix().String() + "/",
+		Orders:   []query.Order{query.OrderByKey{}},
+		Limit:    DefaultPageSize,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range entries {
+		key := datastore.NewKey(e.Key)
+		k, err := parseOutboxKey(key)
+		if err != nil {
+			return i, err
+		}
+		value, err := r.get(ctx, k.Key())
+		if err == datastore.ErrNotFound {
+			err = r.deadLetter(key, k, fmt.Errorf("%w: %s", ErrEventMissing, k.Key()))
+			if err != nil {
+				return i, err
+			}
+			continue
+		} else if err != nil {
+			return i, err
+		}
+		env := &Envelope{}
+		if err := env.UnmarshalBinary(value); err != nil {
+			return i, err
+		}
+		if err := r.publish(ctx, env); errors.Is(err, ErrPermanent) {
+			if err := r.deadLetter(key, k, err); err != nil {
+				return i, err
+			}
+			continue
+		} else if err != nil {
+			return i, err
+		}
+		if err := r.d.store.Delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// get reads the event under key. Stores without atomic transactions may expose an outbox entry before
+// its event, so a missing event is retried like a failed publish, up to the relay's missing retries.
+func (r *Relay) get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	var value []byte
+	err := r.retry(ctx, r.missingRetries, func() (err error) {
+		value, err = r.d.store.Get(key)
+		return err
+	}, func(err error) bool {
+		return err == datastore.ErrNotFound
+	})
+	return value, err
+}
+
+// publish publishes env, retrying until it succeeds, fails permanently, or ctx is done.
+func (r *Relay) publish(ctx context.Context, env *Envelope) error {
+	return r.retry(ctx, -1, func() error {
+		return r.publisher.Publish(ctx, env)
+	}, func(err error) bool {
+		return !errors.Is(err, ErrPermanent)
+	})
+}
+
+// deadLetter moves an outbox entry to the dead letters, recording cause.
+func (r *Relay) deadLetter(key datastore.Key, k EventKey, cause error) error {
+	txn, err := r.d.store.NewTransaction(false)
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+	if err := txn.Delete(key); err != nil {
+		return err
+	}
+	if err := txn.Put(deadLetterKey(k), []byte(cause.Error())); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// retry calls fn until it succeeds, with exponential backoff, as long as its errors are transient, and up
+// to retries times unless retries is negative.
+func (r *Relay) retry(ctx context.Context, retries int, fn func() error, transient func(error) bool) error {
+	delay := r.retryMin
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || !transient(err) || (retries >= 0 && i >= retries) {
+			return err
+		}
+		select {
+		case <-r.clock.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if delay *= 2; delay > r.retryMax {
+			delay = r.retryMax
+		}
+	}
+}
+
+// DeadLetter is an outbox entry that a Relay gave up on.
+type DeadLetter struct {
+	Key EventKey
+	Err string // the error that made the Relay give up
+}
+
+// DeadLetters returns the outbox entries that Relays gave up on, in causal order.
+func (d *Dispatcher) DeadLetters() ([]DeadLetter, error) {
+	result, err := d.store.Query(query.Query{
+		Prefix: datastore.NewKey(deadLettersNamespace).ChildString(KeyVersion).String() + "/",
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, len(entries))
+	for i, e := range entries {
+		k, err := parseEntryKey(deadLettersNamespace, datastore.NewKey(e.Key))
+		if err != nil {
+			return nil, err
+		}
+		letters[i] = DeadLetter{Key: k, Err: string(e.Value)}
+	}
+	return letters, nil
+}
+
+func outboxPrefix() datastore.Key {
+	return datastore.NewKey(outboxNamespace).ChildString(KeyVersion)
+}
+
+// outboxKey returns the outbox key for k.
+func outboxKey(k EventKey) datastore.Key {
+	return entryKey(outboxNamespace, k)
+}
+
+// deadLetterKey returns the dead letter key for k.
+func deadLetterKey(k EventKey) datastore.Key {
+	return entryKey(deadLettersNamespace, k)
+}
+
+// parseOutboxKey rebuilds the primary key referenced by an outbox key.
+func parseOutboxKey(key datastore.Key) (EventKey, error) {
+	return parseEntryKey(outboxNamespace, key)
+}
+
+func entryKey(namespace string, k EventKey) datastore.Key {
+	return datastore.NewKey(namespace).ChildString(KeyVersion).ChildString(encodeTime(k.Stamp.Wall)).
+		ChildString(encodeSeq(k.Stamp)).ChildString(escapeSegment(k.EntityID)).ChildString(escapeSegment(k.Type))
+}
+
+func parseEntryKey(namespace string, key datastore.Key) (EventKey, error) {
+	parts := key.List()
+	if len(parts) != 6 || parts[0] != namespace || parts[1] != KeyVersion {
+		return EventKey{}, ErrInvalidKey
+	}
+	return ParseEventKey(eventsPrefix().ChildString(parts[2]).ChildString(parts[3]).
+		ChildString(parts[4]).ChildString(parts[5]))
+}
+
+// MemoryPublisher is a Publisher keeping published events in memory, for tests.
+type MemoryPublisher struct {
+	lock      sync.Mutex
+	published []*Envelope
+	failures  []error
+	signal    chan struct{}
+}
+
+// NewMemoryPublisher creates an empty MemoryPublisher.
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{signal: make(chan struct{}, 1)}
+}
+
+// Publish records env, or returns the next error queued with Fail.
+func (p *MemoryPublisher) Publish(ctx context.Context, env *Envelope) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.failures) > 0 {
+		err := p.failures[0]
+		p.failures = p.failures[1:]
+		return err
+	}
+	p.published = append(p.published, env)
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Fail makes the next calls to Publish return errs, in order.
+func (p *MemoryPublisher) Fail(errs ...error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.failures = append(p.failures, errs...)
+}
+
+// Published returns the events published so far, in order.
+func (p *MemoryPublisher) Published() []*Envelope {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*Envelope(nil), p.published...)
+}
+
+// Wait blocks until at least n events have been published, or ctx is done, and returns the events
+// published so far.
+func (p *MemoryPublisher) Wait(ctx context.Context, n int) []*Envelope {
+	for {
+		published := p.Published()
+		if len(published) >= n {
+			return published
+		}
+		select {
+		case <-p.signal:
+		case <-ctx.Done():
+			return published
+		}
+	}
+}
+
+// Sanity check
+var _ Publisher = (*MemoryPublisher)(nil)
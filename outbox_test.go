@@ -0,0 +1,188 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
+)
+
+func outboxLen(t *testing.T, d *Dispatcher) int {
+	entries, err := d.Query(query.Query{Prefix: outboxPrefix().String(), KeysOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return len(entries)
+}
+
+func TestOutbox(t *testing.T) {
+	store := NewTxMapDatastore()
+	dispatcher := NewDispatcher(store, WithOutbox())
+	for i := 0; i < 3; i++ {
+		if err := dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Created", Timestamp: time.Now()}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if n := outboxLen(t, dispatcher); n != 3 {
+		t.Fatalf("expected 3 outbox entries, got %d", n)
+	}
+	// Without WithOutbox, nothing is written to the outbox
+	plain := NewDispatcher(NewTxMapDatastore())
+	plain.Dispatch(&testEvent{ID: "a", Kind: "Created", Timestamp: time.Now()})
+	if n := outboxLen(t, plain); n != 0 {
+		t.Errorf("expected no outbox entries, got %d", n)
+	}
+
+	publisher := NewMemoryPublisher()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- NewRelay(dispatcher, publisher).Run(ctx)
+	}()
+	if published := publisher.Wait(ctx, 3); len(published) != 3 {
+		t.Fatalf("expected 3 events published, got %d", len(published))
+	}
+	// Events stored while running are published too, in order
+	if _, err := dispatcher.DispatchAll(
+		&testEvent{ID: "b", Kind: "Created"},
+		&testEvent{ID: "b", Kind: "Updated"},
+	); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	published := publisher.Wait(ctx, 5)
+	if len(published) != 5 {
+		t.Fatalf("expected 5 events published, got %d", len(published))
+	}
+	for i := 1; i < len(published); i++ {
+		if !published[i-1].Stamp.Less(published[i].Stamp) {
+			t.Error("expected events published in order")
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if n := outboxLen(t, dispatcher); n != 0 {
+		t.Errorf("expected an empty outbox, got %d entries", n)
+	}
+}
+
+func TestRelayRetry(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithOutbox())
+	publisher := NewMemoryPublisher()
+	publisher.Fail(errors.New("broker down"), errors.New("broker down"))
+	dispatcher.Dispatch(&testEvent{ID: "a", Kind: "First"})
+	dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Second"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go NewRelay(dispatcher, publisher, WithRetryBackoff(time.Second, time.Minute)).Run(ctx)
+	clk.BlockUntil(1)
+	if len(publisher.Published()) != 0 {
+		t.Fatal("expected nothing published while failing")
+	}
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	// Backoff doubles
+	clk.Advance(time.Second)
+	if len(publisher.Published()) != 0 {
+		t.Fatal("expected nothing published before the backoff elapses")
+	}
+	clk.Advance(time.Second)
+	published := publisher.Wait(ctx, 2)
+	if len(published) != 2 || published[0].Event.Type() != "First" || published[1].Event.Type() != "Second" {
+		t.Errorf("expected both events published in order, got %d", len(published))
+	}
+}
+
+func TestRelayEventNotYetVisible(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	store := NewTxMapDatastore()
+	dispatcher := NewDispatcher(store, WithClock(clk), WithOutbox())
+	// Simulate a store applying a transaction's outbox entry before its event
+	env := &Envelope{Stamp: dispatcher.Clock().Now(), Event: &testEvent{ID: "a", Kind: "Created"}}
+	if err := store.Put(outboxKey(env.Key()), []byte{}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	publisher := NewMemoryPublisher()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewRelay(dispatcher, publisher).Run(ctx)
+	}()
+	clk.BlockUntil(1)
+	b, err := env.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := store.Put(env.Key().Key(), b); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	clk.Advance(DefaultRetryMin)
+	if published := publisher.Wait(ctx, 1); len(published) != 1 {
+		t.Errorf("expected the event published once visible, got %d", len(published))
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestRelayEventMissing(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	store := NewTxMapDatastore()
+	dispatcher := NewDispatcher(store, WithClock(clk), WithOutbox())
+	// An outbox entry whose event never becomes visible is dead-lettered, instead of stalling the relay
+	missing := &Envelope{Stamp: dispatcher.Clock().Now(), Event: &testEvent{ID: "a", Kind: "Lost"}}
+	if err := store.Put(outboxKey(missing.Key()), []byte{}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Created"})
+	publisher := NewMemoryPublisher()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go NewRelay(dispatcher, publisher, WithRetryBackoff(time.Second, time.Minute), WithMissingRetries(2)).Run(ctx)
+	for i := 0; i < 2; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+	}
+	if published := publisher.Wait(ctx, 1); len(published) != 1 || published[0].Event.Type() != "Created" {
+		t.Fatalf("expected the later event published, got %d", len(published))
+	}
+	letters, err := dispatcher.DeadLetters()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(letters) != 1 || letters[0].Key != missing.Key() || !strings.Contains(letters[0].Err, ErrEventMissing.Error()) {
+		t.Errorf("expected the missing event dead-lettered, got %+v", letters)
+	}
+}
+
+func TestRelayPermanentFailure(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithOutbox())
+	publisher := NewMemoryPublisher()
+	publisher.Fail(fmt.Errorf("%w: rejected", ErrPermanent))
+	dispatcher.Dispatch(&testEvent{ID: "a", Kind: "First"})
+	dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Second"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go NewRelay(dispatcher, publisher).Run(ctx)
+	// Permanent failures are not retried
+	if published := publisher.Wait(ctx, 1); len(published) != 1 || published[0].Event.Type() != "Second" {
+		t.Fatalf("expected only the second event published, got %d", len(published))
+	}
+	letters, err := dispatcher.DeadLetters()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(letters) != 1 || letters[0].Key.Type != "First" || letters[0].Err != "permanent failure: rejected" {
+		t.Errorf("expected the first event dead-lettered, got %+v", letters)
+	}
+}
@@ -0,0 +1,565 @@
+package eventstore
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-eventstore/clock"
+)
+
+// Saga streams
+//
+// The state of a saga is event-sourced in a stream of its own, whose entity ID is:
+//
+//     saga/<name>/<correlation-id>
+//
+// Alongside the events recorded by the saga, the stream holds bookkeeping events, whose types start
+// with "saga.": the stamps of the events handled, the commands issued and their delivery, and the
+// timeouts requested and fired. Entity IDs starting with "saga/" are reserved: their events are never
+// handled by sagas.
+
+const sagaPrefix = "saga/"
+
+const (
+	// SagaTimeoutType is the type of the event handed to a saga when one of its timeouts fires. Its body
+	// is the name passed to SagaContext.Timeout.
+	SagaTimeoutType = "saga.timeout"
+	// SagaCommandFailedType is the type of the event applied to a saga when one of its commands is
+	// rejected by its handler. Its body is the error message, and its metadata holds the command type
+	// under SagaCommandTypeKey.
+	SagaCommandFailedType = "saga.command-failed"
+
+	sagaHandledType          = "saga.handled"
+	sagaCompletedType        = "saga.completed"
+	sagaCommandType          = "saga.command"
+	sagaDeliveredType        = "saga.delivered"
+	sagaTimeoutRequestedType = "saga.timeout-requested"
+)
+
+const (
+	// SagaCommandTypeKey is the metadata key of the command type in SagaCommandFailedType events.
+	SagaCommandTypeKey = "saga-command-type"
+
+	sagaIDKey = "saga-id"
+)
+
+// Saga is a long-running workflow, which reacts to events correlated by ID, e.g., those of an order,
+// by recording state changes, issuing commands, and requesting timeouts (see SagaContext).
+type Saga interface {
+	// Apply updates the saga's state from an event it recorded, or from a SagaTimeoutType or
+	// SagaCommandFailedType event. It is called when the saga is loaded from its stream, and as
+	// events are recorded or timeouts fire.
+	Apply(event Event) error
+	// Handle reacts to an event correlated to the saga, or to one of its timeouts firing.
+	Handle(c *SagaContext, event Event) error
+}
+
+// CommandDecoder decodes a command issued by a saga, encoded with its MarshalBinary method.
+type CommandDecoder func(commandType string, data []byte) (Command, error)
+
+// SagaOption configures a SagaManager.
+type SagaOption func(*sagaOptions)
+
+type sagaOptions struct {
+	correlate func(Event) string
+}
+
+// WithCorrelation sets the function returning the ID of the saga an event is correlated to, or "" if
+// the event is not handled by sagas. Defaults to CorrelationID.
+func WithCorrelation(correlate func(Event) string) SagaOption {
+	return func(o *sagaOptions) {
+		o.correlate = correlate
+	}
+}
+
+// SagaManager runs the sagas of a given name, one per correlation ID:
+//
+//     m := eventstore.NewSagaManager(d, bus, "order", newOrderSaga, decodeCommand)
+//     go m.Run(ctx)
+//
+// Events are read with a named Subscription, and each event is handled at most once by its saga, in the
+// same transaction as the resulting state changes. Commands issued by a saga are persisted with them,
+// and then sent through the CommandBus, whose resulting events are persisted in the same transaction as
+// the record of the delivery, so each command takes effect exactly once, even across restarts. Timeouts
+// are persisted too, and fire after a restart if they came due in the meantime.
+type SagaManager struct {
+	name      string
+	d         *Dispatcher
+	bus       *CommandBus
+	new       func() Saga
+	decode    CommandDecoder
+	correlate func(Event) string
+	clock     clock.Clock
+	due       map[sagaTimeoutRef]time.Time // pending timeouts, owned by Run
+}
+
+type sagaTimeoutRef struct {
+	stream string
+	id     int
+}
+
+// sagaState is the bookkeeping of a saga, rebuilt from its stream.
+type sagaState struct {
+	id        string
+	stream    string
+	version   int
+	handled   map[Timestamp]bool
+	completed bool
+	commands  map[int]sagaCommand
+	timeouts  map[int]sagaTimeout
+}
+
+type sagaCommand struct {
+	ID   int
+	Type string
+	Data []byte
+}
+
+type sagaTimeout struct {
+	ID   int
+	At   int64
+	Name string
+}
+
+// NewSagaManager creates a SagaManager for the sagas called name, which must not contain '/'. newSaga
+// creates the empty sagas that their streams are applied to, and decode decodes the commands they issue.
+func NewSagaManager(d *Dispatcher, bus *CommandBus, name string, newSaga func() Saga, decode CommandDecoder, opts ...SagaOption) *SagaManager {
+	o := sagaOptions{correlate: CorrelationID}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &SagaManager{
+		name:      name,
+		d:         d,
+		bus:       bus,
+		new:       newSaga,
+		decode:    decode,
+		correlate: o.correlate,
+		clock:     d.clock.clock,
+	}
+}
+
+// Run handles events and fires timeouts until ctx is done, in which case it returns nil, or an error
+// occurs. It first delivers the commands and schedules the timeouts left pending by a previous run.
+// A single Run per saga name must be active at a time.
+func (m *SagaManager) Run(ctx context.Context) error {
+	// Errors caused by ctx being done, e.g., while loading a saga, just stop the run
+	stop := func(err error) error {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	m.due = make(map[sagaTimeoutRef]time.Time)
+	if err := m.recover(ctx); err != nil {
+		return stop(err)
+	}
+	sub, err := m.d.SubscribeNamed(ctx, sagaPrefix+m.name, SubscriptionFilter{})
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	var timer <-chan time.Time
+	var timerAt time.Time
+	for {
+		if next, ok := m.nextDue(); !ok {
+			timer, timerAt = nil, time.Time{}
+		} else if timer == nil || !next.Equal(timerAt) {
+			timer, timerAt = m.clock.After(next.Sub(m.clock.Now())), next
+		}
+		select {
+		case env, ok := <-sub.Channel():
+			if !ok {
+				return sub.Err()
+			}
+			if err := m.handle(ctx, env); err != nil {
+				return stop(err)
+			}
+			// A handled event that is not acknowledged is skipped by its saga when read again
+			if err := sub.Ack(env.Stamp); err != nil {
+				return stop(err)
+			}
+		case now := <-timer:
+			timer = nil
+			for ref, at := range m.due {
+				if at.After(now) {
+					continue
+				}
+				if err := m.fire(ctx, ref); err != nil {
+					return stop(err)
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Load returns the current state of the saga correlated to id.
+func (m *SagaManager) Load(ctx context.Context, id string) (Saga, error) {
+	_, saga, err := m.load(ctx, id)
+	return saga, err
+}
+
+func (m *SagaManager) nextDue() (time.Time, bool) {
+	var next time.Time
+	for _, at := range m.due {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// recover delivers pending commands, and schedules pending timeouts, of every saga.
+func (m *SagaManager) recover(ctx context.Context) error {
+	result, err := m.d.store.Query(query.Query{
+		Prefix:   indexPrefix(entityIndex, m.stream("")).String(),
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		k, err := parseIndexKey(datastore.NewKey(e.Key))
+		if err != nil {
+			return err
+		}
+		if seen[k.EntityID] {
+			continue
+		}
+		seen[k.EntityID] = true
+		st, _, err := m.load(ctx, strings.TrimPrefix(k.EntityID, m.stream("")))
+		if err != nil {
+			return err
+		}
+		if err := m.settle(ctx, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle hands an event to its saga.
+func (m *SagaManager) handle(ctx context.Context, env *Envelope) error {
+	if strings.HasPrefix(env.Event.EntityID(), sagaPrefix) {
+		return nil
+	}
+	id := m.correlate(env.Event)
+	if id == "" {
+		return nil
+	}
+	st, saga, err := m.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	// Ingested events may be older than those already handled, so replays are told apart by stamp, not position
+	if st.completed || st.handled[env.Stamp] {
+		return nil
+	}
+	c := m.context(ctx, st, saga)
+	c.append(sagaHandledType, encodeStamp(env.Stamp), nil)
+	if err := saga.Handle(c, env.Event); err != nil {
+		return fmt.Errorf("saga `%s` handling event %s: %w", st.stream, env.Stamp, err)
+	}
+	return m.commit(ctx, st, c)
+}
+
+// fire hands a due timeout to its saga.
+func (m *SagaManager) fire(ctx context.Context, ref sagaTimeoutRef) error {
+	delete(m.due, ref)
+	st, saga, err := m.load(ctx, strings.TrimPrefix(ref.stream, m.stream("")))
+	if err != nil {
+		return err
+	}
+	t, ok := st.timeouts[ref.id]
+	if !ok || st.completed {
+		return nil
+	}
+	c := m.context(ctx, st, saga)
+	event := c.append(SagaTimeoutType, []byte(t.Name), map[string]string{sagaIDKey: strconv.Itoa(t.ID)})
+	if err := saga.Apply(event); err != nil {
+		return fmt.Errorf("saga `%s` applying timeout `%s`: %w", st.stream, t.Name, err)
+	}
+	if err := saga.Handle(c, event); err != nil {
+		return fmt.Errorf("saga `%s` handling timeout `%s`: %w", st.stream, t.Name, err)
+	}
+	return m.commit(ctx, st, c)
+}
+
+// commit persists the changes of a saga, then delivers its pending commands and schedules its timeouts.
+func (m *SagaManager) commit(ctx context.Context, st *sagaState, c *SagaContext) error {
+	if envs, err := m.d.Append(ctx, st.stream, st.version, c.events...); envs == nil {
+		return err
+	}
+	st.version += len(c.events)
+	for _, cmd := range c.commands {
+		st.commands[cmd.ID] = cmd
+	}
+	for _, t := range c.timeouts {
+		st.timeouts[t.ID] = t
+	}
+	st.completed = st.completed || c.completed
+	return m.settle(ctx, st)
+}
+
+// settle delivers the pending commands of a saga, and schedules its pending timeouts.
+func (m *SagaManager) settle(ctx context.Context, st *sagaState) error {
+	for _, cmd := range st.commands {
+		if err := m.deliver(ctx, st, cmd); err != nil {
+			return err
+		}
+	}
+	if st.completed {
+		return nil
+	}
+	for _, t := range st.timeouts {
+		m.due[sagaTimeoutRef{stream: st.stream, id: t.ID}] = time.Unix(0, t.At)
+	}
+	return nil
+}
+
+// deliver sends a command through the bus, recording its delivery in the same transaction as its
+// resulting events, or its failure if it is rejected. Errors that do not come from the command, such as
+// the store failing or ctx being done, are returned instead, leaving the command pending for the next run.
+func (m *SagaManager) deliver(ctx context.Context, st *sagaState, cmd sagaCommand) error {
+	delivered := m.event(st, sagaDeliveredType, []byte(strconv.Itoa(cmd.ID)), nil)
+	command, err := m.decode(cmd.Type, cmd.Data)
+	if err == nil {
+		var events []Event
+		if events, err = m.bus.handle(ctx, command); err == nil {
+			if result, err := m.bus.persist(events, []Event{delivered}); result == nil {
+				return err
+			}
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	failed := m.event(st, SagaCommandFailedType, []byte(err.Error()), map[string]string{
+		sagaIDKey:          strconv.Itoa(cmd.ID),
+		SagaCommandTypeKey: cmd.Type,
+	})
+	_, err = m.d.DispatchAll(failed)
+	return err
+}
+
+// load rebuilds the saga correlated to id, and its bookkeeping, from its stream.
+func (m *SagaManager) load(ctx context.Context, id string) (*sagaState, Saga, error) {
+	st := &sagaState{
+		id:       id,
+		stream:   m.stream(id),
+		handled:  make(map[Timestamp]bool),
+		commands: make(map[int]sagaCommand),
+		timeouts: make(map[int]sagaTimeout),
+	}
+	saga := m.new()
+	it := m.d.Events().ForEntity(st.stream).Iter(ctx)
+	defer it.Close()
+	for it.Next() {
+		event := it.Event().Event
+		st.version++
+		if err := st.apply(saga, event); err != nil {
+			return nil, nil, fmt.Errorf("saga `%s` applying event %s: %w", st.stream, it.Event().Stamp, err)
+		}
+	}
+	return st, saga, it.Err()
+}
+
+// apply updates the bookkeeping of a saga from an event of its stream, and applies the event to the
+// saga if it is not bookkeeping only.
+func (st *sagaState) apply(saga Saga, event Event) error {
+	switch event.Type() {
+	case sagaHandledType:
+		ts, err := decodeStamp(event.Body())
+		if err != nil {
+			return err
+		}
+		st.handled[ts] = true
+	case sagaCompletedType:
+		st.completed = true
+	case sagaCommandType:
+		var cmd sagaCommand
+		if err := decodeGob(event.Body(), &cmd); err != nil {
+			return err
+		}
+		st.commands[cmd.ID] = cmd
+	case sagaDeliveredType:
+		id, err := strconv.Atoi(string(event.Body()))
+		if err != nil {
+			return err
+		}
+		delete(st.commands, id)
+	case sagaTimeoutRequestedType:
+		var t sagaTimeout
+		if err := decodeGob(event.Body(), &t); err != nil {
+			return err
+		}
+		st.timeouts[t.ID] = t
+	case SagaCommandFailedType, SagaTimeoutType:
+		id, err := strconv.Atoi(MetadataValue(event, sagaIDKey))
+		if err != nil {
+			return err
+		}
+		if event.Type() == SagaTimeoutType {
+			delete(st.timeouts, id)
+		} else {
+			delete(st.commands, id)
+		}
+		return saga.Apply(event)
+	default:
+		return saga.Apply(event)
+	}
+	return nil
+}
+
+func (m *SagaManager) context(ctx context.Context, st *sagaState, saga Saga) *SagaContext {
+	return &SagaContext{ctx: ctx, m: m, st: st, saga: saga, now: m.clock.Now()}
+}
+
+func (m *SagaManager) stream(id string) string {
+	return sagaPrefix + m.name + "/" + id
+}
+
+// event returns a new event of a saga's stream, outside of its version sequence, e.g., to record
+// deliveries concurrently with the saga handling events.
+func (m *SagaManager) event(st *sagaState, typ string, body []byte, metadata map[string]string) Event {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[CorrelationIDKey] = st.id
+	return &storedEvent{
+		entityID: st.stream,
+		typ:      typ,
+		time:     (&nullEvent{Timestamp: m.clock.Now()}).Time(),
+		body:     body,
+		metadata: metadata,
+	}
+}
+
+// SagaContext is handed to a saga handling an event, to record the saga's reaction. Nothing is persisted
+// until Handle returns without error.
+type SagaContext struct {
+	ctx       context.Context
+	m         *SagaManager
+	st        *sagaState
+	saga      Saga
+	now       time.Time
+	events    []Event
+	commands  []sagaCommand
+	timeouts  []sagaTimeout
+	completed bool
+}
+
+// Context returns the context of the SagaManager's Run.
+func (c *SagaContext) Context() context.Context {
+	return c.ctx
+}
+
+// CorrelationID returns the ID of the saga.
+func (c *SagaContext) CorrelationID() string {
+	return c.st.id
+}
+
+// Now returns the time according to the dispatcher's clock.
+func (c *SagaContext) Now() time.Time {
+	return c.now
+}
+
+// Record applies an event of the given type and body to the saga, and records it in the saga's stream.
+// Types starting with "saga." are reserved.
+func (c *SagaContext) Record(typ string, body []byte) error {
+	if typ == "" || strings.HasPrefix(typ, "saga.") {
+		return fmt.Errorf("%w: reserved saga event type `%s`", ErrInvalidEvent, typ)
+	}
+	event := c.append(typ, body, nil)
+	if err := c.saga.Apply(event); err != nil {
+		c.events = c.events[:len(c.events)-1]
+		return err
+	}
+	return nil
+}
+
+// Send issues a command, which is sent through the CommandBus once the saga's changes are persisted.
+// The command must implement encoding.BinaryMarshaler, and be decodable by the manager's CommandDecoder.
+func (c *SagaContext) Send(cmd Command) error {
+	m, ok := cmd.(encoding.BinaryMarshaler)
+	if !ok {
+		return fmt.Errorf("command %T does not implement encoding.BinaryMarshaler", cmd)
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	record := sagaCommand{ID: c.nextID(), Type: cmd.Type(), Data: data}
+	body, err := encodeGob(record)
+	if err != nil {
+		return err
+	}
+	c.append(sagaCommandType, body, nil)
+	c.commands = append(c.commands, record)
+	return nil
+}
+
+// Timeout requests that a SagaTimeoutType event with the given name as body be handed to the saga at
+// the given time, unless the saga has completed by then.
+func (c *SagaContext) Timeout(at time.Time, name string) error {
+	t := sagaTimeout{ID: c.nextID(), At: at.UnixNano(), Name: name}
+	body, err := encodeGob(t)
+	if err != nil {
+		return err
+	}
+	c.append(sagaTimeoutRequestedType, body, nil)
+	c.timeouts = append(c.timeouts, t)
+	return nil
+}
+
+// Complete ends the saga: it handles no more events, and its pending timeouts never fire. Commands it
+// issued are still delivered.
+func (c *SagaContext) Complete() {
+	if !c.completed {
+		c.append(sagaCompletedType, nil, nil)
+		c.completed = true
+	}
+}
+
+// nextID returns the ID of the next event appended, which is its version in the saga's stream.
+func (c *SagaContext) nextID() int {
+	return c.st.version + len(c.events) + 1
+}
+
+func (c *SagaContext) append(typ string, body []byte, metadata map[string]string) Event {
+	event := c.m.event(c.st, typ, body, metadata)
+	c.events = append(c.events, event)
+	return event
+}
+
+func encodeGob(v interface{}) ([]byte, error) {
+	b := bytes.Buffer{}
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeGob(b []byte, v interface{}) error {
+	if len(b) == 0 {
+		return errors.New("empty encoding")
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
@@ -0,0 +1,268 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/clock"
+)
+
+func (c *testCommand) MarshalBinary() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func decodeTestCommand(commandType string, data []byte) (Command, error) {
+	c := &testCommand{}
+	return c, json.Unmarshal(data, c)
+}
+
+// orderSaga charges an order once placed, and ships it once charged, or cancels it if the charge does
+// not happen within an hour.
+type orderSaga struct {
+	state string
+}
+
+func (s *orderSaga) Apply(event Event) error {
+	switch event.Type() {
+	case SagaTimeoutType:
+		s.state = "expired"
+	case SagaCommandFailedType:
+		s.state = "failed"
+	default:
+		s.state = event.Type()
+	}
+	return nil
+}
+
+func (s *orderSaga) Handle(c *SagaContext, event Event) error {
+	switch event.Type() {
+	case "Placed":
+		if err := c.Record("charging", nil); err != nil {
+			return err
+		}
+		if err := c.Send(&testCommand{Kind: "Charge", ID: c.CorrelationID()}); err != nil {
+			return err
+		}
+		return c.Timeout(c.Now().Add(time.Hour), "charge")
+	case "Charged":
+		if err := c.Record("shipping", nil); err != nil {
+			return err
+		}
+		c.Complete()
+		return c.Send(&testCommand{Kind: "Ship", ID: c.CorrelationID()})
+	case SagaTimeoutType:
+		return c.Send(&testCommand{Kind: "Cancel", ID: c.CorrelationID()})
+	}
+	return nil
+}
+
+// sagaHarness runs an order saga manager, recording the commands it sends.
+type sagaHarness struct {
+	t          *testing.T
+	dispatcher *Dispatcher
+	bus        *CommandBus
+	clock      *clock.Fake
+	sent       chan *testCommand
+
+	lock    sync.Mutex
+	charged bool // whether Charge commands succeed
+	stalled bool // whether Charge commands wait for the run to stop
+}
+
+func newSagaHarness(t *testing.T) *sagaHarness {
+	h := &sagaHarness{
+		t:     t,
+		clock: clock.NewFake(time.Unix(0, 0)),
+		sent:  make(chan *testCommand, 16),
+	}
+	h.dispatcher = NewDispatcher(NewTxMapDatastore(), WithClock(h.clock))
+	h.bus = NewCommandBus(h.dispatcher)
+	handler := CommandHandlerFunc(func(ctx context.Context, cmd Command) ([]Event, error) {
+		c := cmd.(*testCommand)
+		h.sent <- c
+		meta := map[string]string{CorrelationIDKey: c.ID}
+		switch c.Kind {
+		case "Charge":
+			h.lock.Lock()
+			charged, stalled := h.charged, h.stalled
+			h.lock.Unlock()
+			if stalled {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			if !charged {
+				return nil, errors.New("card declined")
+			}
+			return []Event{&testEvent{ID: c.ID, Kind: "Charged", Meta: meta}}, nil
+		case "Ship":
+			return []Event{&testEvent{ID: c.ID, Kind: "Shipped", Meta: meta}}, nil
+		default:
+			return []Event{&testEvent{ID: c.ID, Kind: "Cancelled", Meta: meta}}, nil
+		}
+	})
+	for _, kind := range []string{"Charge", "Ship", "Cancel"} {
+		h.bus.Handle(kind, handler)
+	}
+	return h
+}
+
+// run starts a saga manager, and returns a function stopping it.
+func (h *sagaHarness) run() func() {
+	m := NewSagaManager(h.dispatcher, h.bus, "order", func() Saga { return &orderSaga{} }, decodeTestCommand)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		if err := <-done; err != nil {
+			h.t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+}
+
+func (h *sagaHarness) place(id string) {
+	event := &testEvent{ID: id, Kind: "Placed", Meta: map[string]string{CorrelationIDKey: id}}
+	if err := h.dispatcher.Dispatch(event); err != nil {
+		h.t.Fatalf("unexpected error: %s", err.Error())
+	}
+}
+
+func (h *sagaHarness) expect(kinds ...string) {
+	h.t.Helper()
+	for _, kind := range kinds {
+		select {
+		case c := <-h.sent:
+			if c.Kind != kind {
+				h.t.Fatalf("expected command %s, got %s", kind, c.Kind)
+			}
+		case <-time.After(time.Second):
+			h.t.Fatalf("expected command %s", kind)
+		}
+	}
+}
+
+func (h *sagaHarness) nothing() {
+	h.t.Helper()
+	select {
+	case c := <-h.sent:
+		h.t.Fatalf("unexpected command %s", c.Kind)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (h *sagaHarness) state(id string) string {
+	m := NewSagaManager(h.dispatcher, h.bus, "order", func() Saga { return &orderSaga{} }, decodeTestCommand)
+	saga, err := m.Load(context.Background(), id)
+	if err != nil {
+		h.t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return saga.(*orderSaga).state
+}
+
+func TestSaga(t *testing.T) {
+	h := newSagaHarness(t)
+	h.charged = true
+	stop := h.run()
+	defer stop()
+	h.place("o1")
+	h.expect("Charge", "Ship")
+	h.nothing()
+	if state := h.state("o1"); state != "shipping" {
+		t.Errorf("expected shipping, got %s", state)
+	}
+	// The saga is complete, so its timeout never fires
+	h.clock.Advance(2 * time.Hour)
+	h.nothing()
+}
+
+func TestSagaTimeout(t *testing.T) {
+	h := newSagaHarness(t)
+	stop := h.run()
+	h.place("o1")
+	h.expect("Charge")
+	stop()
+	if state := h.state("o1"); state != "failed" {
+		t.Errorf("expected failed, got %s", state)
+	}
+	// Timeouts survive restarts
+	stop = h.run()
+	defer stop()
+	h.nothing()
+	h.clock.BlockUntil(1)
+	h.clock.Advance(time.Hour)
+	h.expect("Cancel")
+	h.nothing()
+	if state := h.state("o1"); state != "expired" {
+		t.Errorf("expected expired, got %s", state)
+	}
+}
+
+func TestSagaExactlyOnce(t *testing.T) {
+	h := newSagaHarness(t)
+	h.charged = true
+	stop := h.run()
+	h.place("o1")
+	h.expect("Charge", "Ship")
+	stop()
+	// Restarting neither handles events again, nor redelivers commands
+	stop = h.run()
+	defer stop()
+	h.nothing()
+	for kind, expected := range map[string]int{"Charged": 1, "Shipped": 1} {
+		envs, err := h.dispatcher.Events().ForEntity("o1").OfType(kind).Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(envs) != expected {
+			t.Errorf("expected %d %s events, got %d", expected, kind, len(envs))
+		}
+	}
+}
+
+func TestSagaInterruptedDelivery(t *testing.T) {
+	h := newSagaHarness(t)
+	h.stalled = true
+	stop := h.run()
+	h.place("o1")
+	h.expect("Charge")
+	stop()
+	// A command interrupted by the run stopping is not a rejection, and is delivered by the next run
+	if state := h.state("o1"); state != "charging" {
+		t.Errorf("expected charging, got %s", state)
+	}
+	h.lock.Lock()
+	h.stalled, h.charged = false, true
+	h.lock.Unlock()
+	stop = h.run()
+	defer stop()
+	h.expect("Charge", "Ship")
+	h.nothing()
+}
+
+func TestSagaIngestedOlder(t *testing.T) {
+	h := newSagaHarness(t)
+	h.clock.Advance(10 * time.Second)
+	stop := h.run()
+	defer stop()
+	h.place("o1")
+	h.expect("Charge")
+	// A peer's event stamped before the one handled is still handled, once
+	charged := &Envelope{
+		Stamp: Timestamp{Wall: int64(5 * time.Second), Node: h.dispatcher.Clock().Node() + 1},
+		Event: &testEvent{ID: "o1", Kind: "Charged", Meta: map[string]string{CorrelationIDKey: "o1"}},
+	}
+	if err := h.dispatcher.Ingest(charged); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	h.expect("Ship")
+	h.nothing()
+	if state := h.state("o1"); state != "shipping" {
+		t.Errorf("expected shipping, got %s", state)
+	}
+}
@@ -0,0 +1,182 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Scheduled events
+//
+// Events scheduled for dispatch at a later time are stored, until dispatched, under:
+//
+//     /schedules/<version>/<schedule-id>
+//
+// where <schedule-id> is <at>-<time>-<seq>: the due time, in nanoseconds, and the stamp given to the
+// schedule when it was created, encoded as in event keys. Schedules thus sort by due time, and a due
+// schedule is deleted in the same transaction as its event is stored, and deduplicated on its ID like
+// events dispatched with DispatchOnce.
+
+// schedulesNamespace is the root of schedule keys.
+const schedulesNamespace = "schedules"
+
+// ErrScheduleNotFound is returned when cancelling a schedule that does not exist, e.g., because its event
+// has already been dispatched.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule persists an event, to be dispatched once at is reached by the scheduler loop (see
+// RunScheduler). It returns the ID of the schedule, with which it can be cancelled.
+func (d *Dispatcher) Schedule(event Event, at time.Time) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := validateEvent(event); err != nil {
+		return "", err
+	}
+	stamp := d.clock.Now()
+	id := encodeScheduleID(at, stamp)
+	b, err := (&Envelope{Stamp: stamp, Event: event}).MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	if err := d.store.Put(scheduleKey(id), b); err != nil {
+		return "", err
+	}
+	select {
+	case d.scheduled <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Cancel deletes a schedule, so that its event is never dispatched.
+func (d *Dispatcher) Cancel(scheduleID string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	at, stamp, err := parseScheduleID(scheduleID)
+	if err != nil {
+		return err
+	}
+	// The key is rebuilt from the parsed ID, so that it cannot point outside of the schedules
+	key := scheduleKey(encodeScheduleID(at, stamp))
+	exists, err := d.store.Has(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: `%s`", ErrScheduleNotFound, scheduleID)
+	}
+	return d.store.Delete(key)
+}
+
+// RunScheduler dispatches scheduled events as they come due, until ctx is done, in which case it returns
+// nil, or an error occurs. Events that came due while no scheduler was running are dispatched right away,
+// in due order. A single RunScheduler per store must be active at a time.
+//
+// As with Dispatch, a reducer error is returned once the event is persisted, so running the scheduler
+// again resumes with the next scheduled event.
+func (d *Dispatcher) RunScheduler(ctx context.Context) error {
+	c := d.clock.clock
+	for {
+		at, ok, err := d.dispatchDue()
+		if err != nil {
+			return err
+		}
+		var timer <-chan time.Time
+		if ok {
+			timer = c.After(at.Sub(c.Now()))
+		}
+		select {
+		case <-timer:
+		case <-d.scheduled:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// dispatchDue dispatches every due scheduled event, and returns the due time of the next one, if any.
+func (d *Dispatcher) dispatchDue() (time.Time, bool, error) {
+	for {
+		d.lock.Lock()
+		at, ok, err := d.dispatchNext()
+		d.lock.Unlock()
+		if err != nil || !ok || at.After(d.clock.clock.Now()) {
+			return at, ok, err
+		}
+	}
+}
+
+// dispatchNext dispatches the earliest scheduled event if it is due, and returns its due time. As with
+// Dispatch, a reducer error is returned once the event is persisted. It is called with the dispatcher
+// lock held.
+func (d *Dispatcher) dispatchNext() (time.Time, bool, error) {
+	result, err := d.store.Query(query.Query{
+		Prefix: schedulesPrefix().String() + "/",
+		Orders: []query.Order{query.OrderByKey{}},
+		Limit:  1,
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	entries, err := result.Rest()
+	if err != nil || len(entries) == 0 {
+		return time.Time{}, false, err
+	}
+	key := datastore.NewKey(entries[0].Key)
+	at, _, err := parseScheduleID(key.BaseNamespace())
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if at.After(d.clock.clock.Now()) {
+		return at, true, nil
+	}
+	scheduled := &Envelope{}
+	if err := scheduled.UnmarshalBinary(entries[0].Value); err != nil {
+		return time.Time{}, false, err
+	}
+	// Deduplicating on the schedule ID keeps a schedule whose deletion was lost, e.g., in a crash, from
+	// dispatching its event again
+	_, err = d.dispatchOnce(scheduleIDPrefix+key.BaseNamespace(), scheduled.Event, func(txn datastore.Txn) error {
+		return txn.Delete(key)
+	})
+	return at, true, err
+}
+
+func schedulesPrefix() datastore.Key {
+	return datastore.NewKey(schedulesNamespace).ChildString(KeyVersion)
+}
+
+func scheduleKey(id string) datastore.Key {
+	return schedulesPrefix().ChildString(id)
+}
+
+func encodeScheduleID(at time.Time, stamp Timestamp) string {
+	return strings.Join([]string{encodeTime(at.UnixNano()), encodeTime(stamp.Wall), encodeSeq(stamp)}, "-")
+}
+
+// parseScheduleID returns the due time and stamp of a schedule.
+func parseScheduleID(id string) (time.Time, Timestamp, error) {
+	invalid := fmt.Errorf("%w: invalid schedule id `%s`", ErrScheduleNotFound, id)
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 {
+		return time.Time{}, Timestamp{}, invalid
+	}
+	at, err := decodeTime(parts[0])
+	if err != nil {
+		return time.Time{}, Timestamp{}, invalid
+	}
+	wall, err := decodeTime(parts[1])
+	if err != nil {
+		return time.Time{}, Timestamp{}, invalid
+	}
+	logical, node, err := decodeSeq(parts[2])
+	if err != nil {
+		return time.Time{}, Timestamp{}, invalid
+	}
+	return time.Unix(0, at), Timestamp{Wall: wall, Logical: logical, Node: node}, nil
+}
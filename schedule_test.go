@@ -0,0 +1,152 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/clock"
+)
+
+func TestSchedule(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk))
+	sub := dispatcher.Subscribe(context.Background(), Timestamp{}, SubscriptionFilter{})
+	defer sub.Close()
+	// Due events are dispatched in due order, regardless of scheduling order
+	for i, kind := range []string{"Second", "Cancelled", "First"} {
+		id, err := dispatcher.Schedule(&testEvent{ID: "a", Kind: kind}, clk.Now().Add(time.Duration(3-i)*time.Minute))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if kind == "Cancelled" {
+			if err := dispatcher.Cancel(id); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if err := dispatcher.Cancel(id); !errors.Is(err, ErrScheduleNotFound) {
+				t.Errorf("expected schedule not found, got %v", err)
+			}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- dispatcher.RunScheduler(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}()
+	clk.BlockUntil(1)
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 0 {
+		t.Fatal("expected nothing dispatched before due")
+	}
+	clk.Advance(time.Minute)
+	if env := receive(t, sub, 1)[0]; env.Event.Type() != "First" {
+		t.Errorf("expected First, got %s", env.Event.Type())
+	}
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Minute)
+	if env := receive(t, sub, 1)[0]; env.Event.Type() != "Second" {
+		t.Errorf("expected Second, got %s", env.Event.Type())
+	}
+	// Schedules added while running wake the scheduler
+	if _, err := dispatcher.Schedule(&testEvent{ID: "a", Kind: "Late"}, clk.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if env := receive(t, sub, 1)[0]; env.Event.Type() != "Late" {
+		t.Errorf("expected Late, got %s", env.Event.Type())
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 3 {
+		t.Errorf("expected 3 events dispatched, got %d", version)
+	}
+}
+
+func TestScheduleSurvivesRestart(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	store := NewTxMapDatastore()
+	dispatcher := NewDispatcher(store, WithClock(clk))
+	if _, err := dispatcher.Schedule(&testEvent{ID: "a", Kind: "Reminder"}, clk.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	clk.Advance(2 * time.Hour)
+	// A new dispatcher on the same store dispatches the overdue event as soon as it runs
+	dispatcher = NewDispatcher(store, WithClock(clk))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.RunScheduler(ctx)
+	sub := dispatcher.Subscribe(ctx, Timestamp{}, SubscriptionFilter{})
+	if env := receive(t, sub, 1)[0]; env.Event.Type() != "Reminder" {
+		t.Errorf("expected Reminder, got %s", env.Event.Type())
+	}
+}
+
+func TestScheduleDispatchedOnce(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	store := NewTxMapDatastore()
+	dispatcher := NewDispatcher(store, WithClock(clk))
+	id, err := dispatcher.Schedule(&testEvent{ID: "a", Kind: "Reminder"}, clk.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	scheduled, err := store.Get(scheduleKey(id))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := dispatcher.dispatchDue(); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		// As if the deletion of the schedule had been lost
+		if i == 0 {
+			store.Put(scheduleKey(id), scheduled)
+		}
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 1 {
+		t.Errorf("expected 1 event dispatched, got %d", version)
+	}
+	if exists, _ := store.Has(scheduleKey(id)); exists {
+		t.Error("expected the schedule deleted")
+	}
+}
+
+func TestCancelInvalidID(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	if err := dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Created"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	valid := encodeTime(0)
+	for _, id := range []string{
+		"nope",
+		valid + "-" + valid,
+		valid + "-" + valid + "-" + encodeSeq(Timestamp{}) + "-" + valid,
+		// Would point at the version of "a" if joined to the schedules prefix as is
+		valid + "-" + valid + "-/../../../versions/v1/a",
+	} {
+		if err := dispatcher.Cancel(id); !errors.Is(err, ErrScheduleNotFound) {
+			t.Errorf("%s: expected schedule not found, got %v", id, err)
+		}
+	}
+	if version, err := dispatcher.Version(context.Background(), "a"); err != nil || version != 1 {
+		t.Errorf("expected version 1, got %d (%v)", version, err)
+	}
+}
+
+func TestScheduleReducerError(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk))
+	dispatcher.Register(&errorReducer{})
+	if _, err := dispatcher.Schedule(&testEvent{ID: "a", Kind: "Reminder"}, clk.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// The event is persisted before the reducer error is returned
+	if err := dispatcher.RunScheduler(context.Background()); err == nil {
+		t.Error("expected reducer error")
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 1 {
+		t.Errorf("expected 1 event dispatched, got %d", version)
+	}
+}
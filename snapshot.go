@@ -0,0 +1,208 @@
+package eventstore
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Snapshots
+//
+// A snapshot is the encoded state of an aggregate or view after applying a number of events, so that it
+// can be rebuilt from the snapshot and the events after it, rather than from its whole history.
+// Snapshots are stored under:
+//
+//     /snapshots/<version>/<id>/<snapshot-version>
+//
+// where <id> is the entity ID of an aggregate, or the name of a view, escaped as in event keys, and
+// <snapshot-version> is the number of events applied, as 16 hex digits, so that the latest snapshot of
+// an ID sorts last.
+//
+// Events are found after a snapshot by its Stamp. Events ingested from peers with an older stamp than a
+// snapshot's are therefore not applied on top of it: Repository.Load detects this by comparing the
+// aggregate's version with the entity's, and CatchUp by comparing the view's with the number of events,
+// and both then ignore the snapshot and replay every event instead.
+
+// snapshotsNamespace is the root of snapshot keys.
+const snapshotsNamespace = "snapshots"
+
+// ErrNoSnapshot is returned when no snapshot is stored for an ID.
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// Snapshot is the state of an aggregate or view at a given version.
+type Snapshot struct {
+	ID      string    // entity ID of an aggregate, or name of a view
+	Version int       // number of events applied
+	Stamp   Timestamp // stamp of the last event applied
+	Data    []byte    // encoded state
+}
+
+// SnapshotStore persists snapshots in a datastore, typically the Dispatcher's store.
+type SnapshotStore struct {
+	store datastore.Datastore
+}
+
+// NewSnapshotStore creates a SnapshotStore backed by store.
+func NewSnapshotStore(store datastore.Datastore) *SnapshotStore {
+	return &SnapshotStore{store: store}
+}
+
+// Save stores a snapshot, replacing any existing snapshot of the same ID and version.
+func (s *SnapshotStore) Save(snap Snapshot) error {
+	b := bytes.Buffer{}
+	if err := gob.NewEncoder(&b).Encode(snap); err != nil {
+		return err
+	}
+	return s.store.Put(snapshotKey(snap.ID, snap.Version), b.Bytes())
+}
+
+// Take encodes state and stores it as the snapshot of id at the given version and stamp.
+func (s *SnapshotStore) Take(id string, version int, stamp Timestamp, state encoding.BinaryMarshaler) error {
+	data, err := state.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.Save(Snapshot{ID: id, Version: version, Stamp: stamp, Data: data})
+}
+
+// Get returns the snapshot of id at the given version, or ErrNoSnapshot.
+func (s *SnapshotStore) Get(id string, version int) (Snapshot, error) {
+	b, err := s.store.Get(snapshotKey(id, version))
+	if err == datastore.ErrNotFound {
+		return Snapshot{}, fmt.Errorf("%w: `%s` at version %d", ErrNoSnapshot, id, version)
+	} else if err != nil {
+		return Snapshot{}, err
+	}
+	return decodeSnapshot(b)
+}
+
+// Latest returns the snapshot of id with the highest version, or ErrNoSnapshot.
+func (s *SnapshotStore) Latest(id string) (Snapshot, error) {
+	result, err := s.store.Query(query.Query{
+		Prefix: snapshotPrefix(id).String() + "/",
+		Orders: []query.Order{query.OrderByKeyDescending{}},
+		Limit:  1,
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(entries) == 0 {
+		return Snapshot{}, fmt.Errorf("%w: `%s`", ErrNoSnapshot, id)
+	}
+	return decodeSnapshot(entries[0].Value)
+}
+
+// restore decodes the latest snapshot of id into state, and returns it. It returns a zero Snapshot if
+// there is none.
+func (s *SnapshotStore) restore(id string, state encoding.BinaryUnmarshaler) (Snapshot, error) {
+	snap, err := s.Latest(id)
+	if errors.Is(err, ErrNoSnapshot) {
+		return Snapshot{}, nil
+	} else if err != nil {
+		return Snapshot{}, err
+	}
+	if err := state.UnmarshalBinary(snap.Data); err != nil {
+		return Snapshot{}, fmt.Errorf("snapshot of `%s` at version %d: %w", id, snap.Version, err)
+	}
+	return snap, nil
+}
+
+// SnapshotPolicy decides whether to snapshot an aggregate saved from version `from` to version `to`.
+type SnapshotPolicy func(from, to int) bool
+
+// SnapshotEvery snapshots aggregates every n events.
+func SnapshotEvery(n int) SnapshotPolicy {
+	return func(from, to int) bool {
+		return n > 0 && from/n != to/n
+	}
+}
+
+// SnapshotOnDemand never snapshots aggregates automatically. Snapshots are taken with Repository.Snapshot.
+func SnapshotOnDemand(from, to int) bool {
+	return false
+}
+
+// CatchUp brings a view up to date with the store: the view is restored from the latest snapshot stored
+// under name, if it implements encoding.BinaryUnmarshaler, and then reduces all events after it. It
+// returns the position reached, with a nil Data, from which to Subscribe to live events, and to later
+// Take a new snapshot. A snapshot that misses ingested events is ignored, and the view reduces every
+// event instead.
+func (d *Dispatcher) CatchUp(ctx context.Context, snapshots *SnapshotStore, name string, view Reducer) (Snapshot, error) {
+	snap := Snapshot{ID: name}
+	if u, ok := view.(encoding.BinaryUnmarshaler); ok {
+		latest, err := d.freshSnapshot(ctx, snapshots, name)
+		if err != nil {
+			return snap, err
+		}
+		if latest.Version > 0 {
+			if err := u.UnmarshalBinary(latest.Data); err != nil {
+				return snap, fmt.Errorf("snapshot of `%s` at version %d: %w", name, latest.Version, err)
+			}
+			snap.Version, snap.Stamp = latest.Version, latest.Stamp
+		}
+	}
+	q := d.Events()
+	if snap.Version > 0 {
+		q = q.After(snap.Stamp)
+	}
+	it := q.Iter(ctx)
+	defer it.Close()
+	for it.Next() {
+		if err := view.Reduce(it.Event().Event); err != nil {
+			return snap, err
+		}
+		snap.Version++
+		snap.Stamp = it.Event().Stamp
+	}
+	return snap, it.Err()
+}
+
+// freshSnapshot returns the latest snapshot of a view, provided it accounts for every event stamped
+// before it, or a zero Snapshot.
+func (d *Dispatcher) freshSnapshot(ctx context.Context, snapshots *SnapshotStore, name string) (Snapshot, error) {
+	// Read the count first, so that events stored meanwhile are counted after the snapshot, not as missed
+	total, err := d.count()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snap, err := snapshots.Latest(name)
+	if errors.Is(err, ErrNoSnapshot) {
+		return Snapshot{}, nil
+	} else if err != nil {
+		return Snapshot{}, err
+	}
+	after, err := d.Events().After(snap.Stamp).count(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snap.Version+after < total {
+		return Snapshot{}, nil
+	}
+	return snap, nil
+}
+
+func snapshotPrefix(id string) datastore.Key {
+	return datastore.NewKey(snapshotsNamespace).ChildString(KeyVersion).ChildString(escapeSegment(id))
+}
+
+func snapshotKey(id string, version int) datastore.Key {
+	return snapshotPrefix(id).ChildString(fmt.Sprintf("%016x", uint64(version)))
+}
+
+func decodeSnapshot(b []byte) (Snapshot, error) {
+	var snap Snapshot
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&snap); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
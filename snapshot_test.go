@@ -0,0 +1,207 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-eventstore/clock"
+)
+
+// snapshotCounter is a counter that can be snapshotted, and records how many events it applied.
+type snapshotCounter struct {
+	counter
+	applied int
+}
+
+func (c *snapshotCounter) Apply(event Event) error {
+	c.applied++
+	return c.counter.Apply(event)
+}
+
+func (c *snapshotCounter) MarshalBinary() ([]byte, error) {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, uint64(c.count))], nil
+}
+
+func (c *snapshotCounter) UnmarshalBinary(b []byte) error {
+	n, read := binary.Uvarint(b)
+	if read <= 0 {
+		return errors.New("invalid counter snapshot")
+	}
+	c.count = int(n)
+	return nil
+}
+
+func TestSnapshotStore(t *testing.T) {
+	snapshots := NewSnapshotStore(NewTxMapDatastore())
+	if _, err := snapshots.Latest("a"); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("expected no snapshot, got %v", err)
+	}
+	for _, v := range []int{2, 10, 9} {
+		if err := snapshots.Save(Snapshot{ID: "a", Version: v, Stamp: Timestamp{Wall: int64(v)}}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if err := snapshots.Save(Snapshot{ID: "ab", Version: 20}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	latest, err := snapshots.Latest("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if latest.Version != 10 || latest.Stamp.Wall != 10 {
+		t.Errorf("expected latest snapshot at version 10, got %+v", latest)
+	}
+	if snap, err := snapshots.Get("a", 9); err != nil || snap.Version != 9 {
+		t.Errorf("expected snapshot at version 9, got %+v (%v)", snap, err)
+	}
+	if _, err := snapshots.Get("a", 3); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("expected no snapshot, got %v", err)
+	}
+}
+
+func TestRepositorySnapshots(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	snapshots := NewSnapshotStore(dispatcher.Store())
+	repo := NewRepository(dispatcher, func() *snapshotCounter { return &snapshotCounter{} }, WithSnapshots(snapshots, SnapshotEvery(2)))
+	ctx := context.Background()
+	c, _ := repo.Load(ctx, "c1")
+	for i := 0; i < 3; i++ {
+		if err := Raise(c, &testEvent{ID: "c1", Kind: "Incremented", Timestamp: time.Now()}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if err := repo.Save(context.Background(), c); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	snap, err := snapshots.Latest("c1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if snap.Version != 2 {
+		t.Errorf("expected snapshot at version 2, got %d", snap.Version)
+	}
+	loaded, err := repo.Load(ctx, "c1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if loaded.count != 3 || loaded.Version() != 3 || loaded.applied != 1 {
+		t.Errorf("expected count 3 at version 3 with 1 event applied, got %d at %d with %d", loaded.count, loaded.Version(), loaded.applied)
+	}
+	// On demand snapshots are taken at the loaded version
+	if err := repo.Snapshot(loaded); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	loaded, _ = repo.Load(ctx, "c1")
+	if loaded.count != 3 || loaded.Version() != 3 || loaded.applied != 0 {
+		t.Errorf("expected count 3 at version 3 with no event applied, got %d at %d with %d", loaded.count, loaded.Version(), loaded.applied)
+	}
+}
+
+func TestRepositorySnapshotMissesIngested(t *testing.T) {
+	clk := clock.NewFake(time.Unix(100, 0))
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithClock(clk), WithNodeID(1))
+	snapshots := NewSnapshotStore(dispatcher.Store())
+	repo := NewRepository(dispatcher, func() *snapshotCounter { return &snapshotCounter{} }, WithSnapshots(snapshots, SnapshotEvery(2)))
+	ctx := context.Background()
+	c, _ := repo.Load(ctx, "c1")
+	for i := 0; i < 2; i++ {
+		if err := Raise(c, &testEvent{ID: "c1", Kind: "Incremented", Timestamp: clk.Now()}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if err := repo.Save(context.Background(), c); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	// A peer's event stamped before the snapshot is not found after it
+	older := &Envelope{
+		Stamp: Timestamp{Wall: time.Unix(50, 0).UnixNano(), Node: 2},
+		Event: &testEvent{ID: "c1", Kind: "Incremented", Timestamp: clk.Now()},
+	}
+	if err := dispatcher.Ingest(older); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	loaded, err := repo.Load(ctx, "c1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if loaded.count != 3 || loaded.Version() != 3 || loaded.applied != 3 {
+		t.Errorf("expected count 3 at version 3 with 3 events applied, got %d at %d with %d", loaded.count, loaded.Version(), loaded.applied)
+	}
+	if err := Raise(loaded, &testEvent{ID: "c1", Kind: "Incremented", Timestamp: clk.Now()}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := repo.Save(context.Background(), loaded); err != nil {
+		t.Errorf("unexpected error saving after reload: %s", err.Error())
+	}
+}
+
+// countingView counts reduced events, and can be snapshotted.
+type countingView struct {
+	snapshotCounter
+}
+
+func (v *countingView) Reduce(event Event) error {
+	return v.Apply(event)
+}
+
+func TestCatchUp(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	snapshots := NewSnapshotStore(dispatcher.Store())
+	ctx := context.Background()
+	view := &countingView{}
+	pos, err := dispatcher.CatchUp(ctx, snapshots, "view", view)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if pos.Version != 12 || view.applied != 12 {
+		t.Fatalf("expected 12 events applied, got %d", view.applied)
+	}
+	if err := snapshots.Take("view", pos.Version, pos.Stamp, view); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Incremented", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	view = &countingView{}
+	pos, err = dispatcher.CatchUp(ctx, snapshots, "view", view)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if pos.Version != 13 || view.applied != 1 || view.count != 1 {
+		t.Errorf("expected only the event after the snapshot to be applied, got %+v applied %d", pos, view.applied)
+	}
+}
+
+func TestCatchUpMissesIngested(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	snapshots := NewSnapshotStore(dispatcher.Store())
+	ctx := context.Background()
+	view := &countingView{}
+	pos, err := dispatcher.CatchUp(ctx, snapshots, "view", view)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := snapshots.Take("view", pos.Version, pos.Stamp, view); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// A peer's event stamped before the snapshot is not found after it
+	older := &Envelope{
+		Stamp: Timestamp{Wall: int64(5500 * time.Millisecond), Node: dispatcher.Clock().Node() + 1},
+		Event: &testEvent{ID: "d", Kind: "Incremented"},
+	}
+	if err := dispatcher.Ingest(older); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	view = &countingView{}
+	pos, err = dispatcher.CatchUp(ctx, snapshots, "view", view)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if pos.Version != 13 || view.applied != 13 || view.count != 1 {
+		t.Errorf("expected every event to be applied, got %+v applied %d", pos, view.applied)
+	}
+}
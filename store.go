@@ -0,0 +1,95 @@
+package eventstore
+
+import (
+	"context"
+	"time"
+)
+
+// EventStore is the interface to an event store's entity streams, implemented both by the local
+// Dispatcher and by remote clients (see package api), so that code can use either.
+type EventStore interface {
+	// Append appends events to an entity's stream, provided it is at expectedVersion (see
+	// Dispatcher.Append).
+	Append(ctx context.Context, entityID string, expectedVersion int, events ...Event) ([]*Envelope, error)
+	// Version returns the number of events stored for an entity.
+	Version(ctx context.Context, entityID string) (int, error)
+	// ReadStream iterates over the events of an entity stamped after a position, in order. Pass the zero
+	// Timestamp to read the whole stream.
+	ReadStream(ctx context.Context, entityID string, after Timestamp) EnvelopeIterator
+	// Find iterates over the events matching criteria.
+	Find(ctx context.Context, criteria Criteria) EnvelopeIterator
+	// Watch streams the events matching filter stamped after from, and then live events (see
+	// Dispatcher.Subscribe).
+	Watch(ctx context.Context, from Timestamp, filter SubscriptionFilter) (EventStream, error)
+}
+
+// EnvelopeIterator reads stored events one at a time, such as an EventIterator, so that long reads do not
+// have to fit in memory. Iteration stops when its context is done, in which case Err returns the context's
+// error.
+type EnvelopeIterator interface {
+	// Next advances the iterator, and reports whether there is an event to read.
+	Next() bool
+	// Event returns the current event.
+	Event() *Envelope
+	// Err returns the error, if any, that stopped the iteration.
+	Err() error
+	// Close stops the iteration. It is safe to call more than once.
+	Close() error
+}
+
+// EventStream is a stream of events, such as a Subscription.
+type EventStream interface {
+	// Channel returns the channel that receives events. It is closed when the stream ends.
+	Channel() <-chan *Envelope
+	// Err returns the error, if any, that ended the stream, once its channel is closed.
+	Err() error
+	// Close ends the stream.
+	Close() error
+}
+
+// Criteria selects events like an EventQuery does, as plain values that can be sent over the network.
+// Zero values do not restrict the selection.
+type Criteria struct {
+	Type     string
+	EntityID string
+	From     time.Time // with To, restricts events to those stamped in [From, To)
+	To       time.Time
+	After    Timestamp
+	Limit    int
+	Reverse  bool
+}
+
+// Match returns a query for the events matching criteria.
+func (q EventQuery) Match(c Criteria) EventQuery {
+	q = q.OfType(c.Type).ForEntity(c.EntityID).Limit(c.Limit)
+	if !c.To.IsZero() {
+		q = q.Between(c.From, c.To)
+	}
+	if !c.After.IsZero() {
+		q = q.After(c.After)
+	}
+	if c.Reverse {
+		q = q.Reverse()
+	}
+	return q
+}
+
+// ReadStream iterates over the events of an entity stamped after a position, in order.
+func (d *Dispatcher) ReadStream(ctx context.Context, entityID string, after Timestamp) EnvelopeIterator {
+	return d.Find(ctx, Criteria{EntityID: entityID, After: after})
+}
+
+// Find iterates over the events matching criteria.
+func (d *Dispatcher) Find(ctx context.Context, criteria Criteria) EnvelopeIterator {
+	return d.Events().Match(criteria).Iter(ctx)
+}
+
+// Watch is like Subscribe, returning the Subscription as an EventStream.
+func (d *Dispatcher) Watch(ctx context.Context, from Timestamp, filter SubscriptionFilter) (EventStream, error) {
+	return d.Subscribe(ctx, from, filter), nil
+}
+
+// Sanity check
+var _ EventStore = (*Dispatcher)(nil)
+var _ EventStream = (*Subscription)(nil)
+var _ EnvelopeIterator = (*EventIterator)(nil)
@@ -0,0 +1,65 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadStream(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	envs, err := readAll(dispatcher.ReadStream(context.Background(), "b", Timestamp{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(envs) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(envs))
+	}
+	rest, err := readAll(dispatcher.ReadStream(context.Background(), "b", envs[1].Stamp))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rest) != 2 || rest[0].Stamp != envs[2].Stamp {
+		t.Errorf("expected the last 2 events, got %d", len(rest))
+	}
+}
+
+func TestFind(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	tests := []struct {
+		name     string
+		criteria Criteria
+		walls    []int64 // expected stamps, in seconds
+	}{
+		{"all", Criteria{}, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{"type and entity", Criteria{Type: "Updated", EntityID: "b"}, []int64{2, 8}},
+		{"between", Criteria{From: time.Unix(3, 0), To: time.Unix(6, 0)}, []int64{3, 4, 5}},
+		{"after", Criteria{Type: "Updated", After: Timestamp{Wall: int64(6 * time.Second), Node: dispatcher.Clock().Node()}}, []int64{8, 10, 12}},
+		{"reverse limit", Criteria{EntityID: "a", Limit: 2, Reverse: true}, []int64{10, 7}},
+	}
+	for _, test := range tests {
+		envs, err := readAll(dispatcher.Find(context.Background(), test.criteria))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err.Error())
+		}
+		if len(envs) != len(test.walls) {
+			t.Errorf("%s: expected %d events, got %d", test.name, len(test.walls), len(envs))
+			continue
+		}
+		for i, env := range envs {
+			if env.Stamp.Wall != test.walls[i]*int64(time.Second) {
+				t.Errorf("%s: unexpected stamp %s at position %d", test.name, env.Stamp, i)
+			}
+		}
+	}
+}
+
+// readAll reads the events of it until it is exhausted.
+func readAll(it EnvelopeIterator) ([]*Envelope, error) {
+	defer it.Close()
+	var envs []*Envelope
+	for it.Next() {
+		envs = append(envs, it.Event())
+	}
+	return envs, it.Err()
+}
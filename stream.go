@@ -0,0 +1,159 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Entity streams
+//
+// The events of an entity form its stream, and the number of events in the stream is the entity's
+// version. Versions are kept under:
+//
+//     /versions/<version>/<entity-id>
+//
+// as 8-byte big-endian integers, updated in the same transaction as the events themselves. Entities
+// written before versions were tracked have no version key, and their version is counted from the
+// entity index instead. The total number of events is kept likewise under:
+//
+//     /counts/<version>/events
+
+// versionsNamespace is the root of entity version keys.
+const versionsNamespace = "versions"
+
+// countsNamespace is the root of the event count key.
+const countsNamespace = "counts"
+
+// AnyVersion can be passed to Append to skip the optimistic concurrency check.
+const AnyVersion = -1
+
+// ErrVersionConflict is returned when appending to an entity stream whose version is not the expected one.
+var ErrVersionConflict = errors.New("version conflict")
+
+// Version returns the number of events stored for an entity.
+func (d *Dispatcher) Version(ctx context.Context, entityID string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return d.version(entityID)
+}
+
+// Append stamps, persists, and dispatches events to an entity's stream, in a single transaction, provided
+// the entity is at expectedVersion. Otherwise, nothing is written and an error wrapping ErrVersionConflict
+// is returned. Pass AnyVersion to append regardless of the current version. All events must belong to
+// entityID. Events implementing IdentifiedEvent are deduplicated like with DispatchAll, and retrying an
+// append whose events are all duplicates returns their original envelopes, whatever expectedVersion.
+// Nothing is written if ctx is done by the time the dispatcher lock is acquired.
+func (d *Dispatcher) Append(ctx context.Context, entityID string, expectedVersion int, events ...Event) ([]*Envelope, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if err := validateEvent(event); err != nil {
+			return nil, err
+		}
+		if event.EntityID() != entityID {
+			return nil, fmt.Errorf("%w: event of entity `%s` appended to `%s`", ErrInvalidEvent, event.EntityID(), entityID)
+		}
+	}
+	if expectedVersion != AnyVersion {
+		// Retries of an append that went through are not conflicts
+		dup, err := d.duplicates(events)
+		if err != nil {
+			return nil, err
+		}
+		if dup {
+			return d.dispatchAll(events)
+		}
+		version, err := d.version(entityID)
+		if err != nil {
+			return nil, err
+		}
+		if version != expectedVersion {
+			return nil, fmt.Errorf("%w: `%s` is at version %d, expected %d", ErrVersionConflict, entityID, version, expectedVersion)
+		}
+	}
+	return d.dispatchAll(events)
+}
+
+// version returns the number of events stored for an entity. It is called with the dispatcher lock held.
+func (d *Dispatcher) version(entityID string) (int, error) {
+	b, err := d.store.Get(versionKey(entityID))
+	if err == nil {
+		if len(b) != 8 {
+			return 0, fmt.Errorf("version of `%s`: invalid encoding", entityID)
+		}
+		return int(binary.BigEndian.Uint64(b)), nil
+	} else if err != datastore.ErrNotFound {
+		return 0, err
+	}
+	result, err := d.store.Query(query.Query{
+		Prefix:   indexPrefix(entityIndex, entityID).String() + "/",
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+	n := 0
+	for res := range result.Next() {
+		if res.Error != nil {
+			return 0, res.Error
+		}
+		n++
+	}
+	return n, nil
+}
+
+// count returns the total number of events stored. It is called with the dispatcher lock held, or when
+// a count that is out of date by concurrent writes is acceptable.
+func (d *Dispatcher) count() (int, error) {
+	b, err := d.store.Get(countKey())
+	if err == nil {
+		if len(b) != 8 {
+			return 0, errors.New("event count: invalid encoding")
+		}
+		return int(binary.BigEndian.Uint64(b)), nil
+	} else if err != datastore.ErrNotFound {
+		return 0, err
+	}
+	result, err := d.store.Query(query.Query{
+		Prefix:   eventsPrefix().String() + "/",
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+	n := 0
+	for res := range result.Next() {
+		if res.Error != nil {
+			return 0, res.Error
+		}
+		n++
+	}
+	return n, nil
+}
+
+func countKey() datastore.Key {
+	return datastore.NewKey(countsNamespace).ChildString(KeyVersion).ChildString("events")
+}
+
+func versionKey(entityID string) datastore.Key {
+	return datastore.NewKey(versionsNamespace).ChildString(KeyVersion).ChildString(escapeSegment(entityID))
+}
+
+func encodeVersion(v int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
@@ -0,0 +1,79 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore/query"
+)
+
+func TestVersion(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	for id, expected := range map[string]int{"a": 4, "b": 4, "c": 4, "d": 0} {
+		version, err := dispatcher.Version(context.Background(), id)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if version != expected {
+			t.Errorf("expected `%s` at version %d, got %d", id, expected, version)
+		}
+	}
+}
+
+func TestVersionWithoutVersionKey(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	// Stores written before versions were tracked are counted from the entity index
+	if err := dispatcher.store.Delete(versionKey("a")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if version, err := dispatcher.Version(context.Background(), "a"); err != nil || version != 4 {
+		t.Errorf("expected version 4, got %d (%v)", version, err)
+	}
+	if err := dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Updated", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if version, err := dispatcher.Version(context.Background(), "a"); err != nil || version != 5 {
+		t.Errorf("expected version 5, got %d (%v)", version, err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	events := []Event{
+		&testEvent{ID: "a", Kind: "Updated", Timestamp: time.Now()},
+		&testEvent{ID: "a", Kind: "Deleted", Timestamp: time.Now()},
+	}
+	envs, err := dispatcher.Append(context.Background(), "a", 4, events...)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(envs) != 2 || !envs[0].Stamp.Less(envs[1].Stamp) {
+		t.Fatal("expected events stamped in order")
+	}
+	if version, _ := dispatcher.Version(context.Background(), "a"); version != 6 {
+		t.Errorf("expected version 6, got %d", version)
+	}
+	if _, err := dispatcher.Append(context.Background(), "a", 4, events...); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+	if _, err := dispatcher.Append(context.Background(), "a", AnyVersion, events[0]); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if _, err := dispatcher.Append(context.Background(), "b", AnyVersion, events[0]); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected invalid event, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dispatcher.Append(ctx, "a", AnyVersion, events[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	entries, err := dispatcher.Query(query.Query{Filters: []query.Filter{FilterEntity{EntityID: "a"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(entries) != 7 {
+		t.Errorf("expected 7 events for `a`, got %d", len(entries))
+	}
+}
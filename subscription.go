@@ -0,0 +1,276 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	datastore "github.com/ipfs/go-datastore"
+)
+
+// cursorsNamespace is the root of persisted consumer cursors.
+const cursorsNamespace = "cursors"
+
+// DefaultSubscriptionBuffer is the number of live events a Subscription buffers by default.
+const DefaultSubscriptionBuffer = 1024
+
+var (
+	// ErrSubscriptionClosed is returned when acknowledging events on a closed subscription.
+	ErrSubscriptionClosed = errors.New("subscription closed")
+	// ErrSubscriptionOverflow ends a subscription whose consumer fell too far behind live events.
+	ErrSubscriptionOverflow = errors.New("subscription buffer overflow")
+)
+
+// SubscriptionFilter selects the events delivered to a Subscription. Empty fields match any event.
+type SubscriptionFilter struct {
+	Type     string
+	EntityID string
+}
+
+func (f SubscriptionFilter) match(event Event) bool {
+	return (f.Type == "" || f.Type == event.Type()) && (f.EntityID == "" || f.EntityID == event.EntityID())
+}
+
+// Subscription delivers the events stored after a given position, followed by live events as they are
+// dispatched or ingested, in a single gap-free stream.
+//
+// Historical events are read from the store page by page as the consumer keeps up. Live events arriving
+// in the meantime are buffered, and delivered once the history is exhausted. The buffer is bounded (see
+// WithSubscriptionBuffer): a consumer that falls further behind ends the subscription with
+// ErrSubscriptionOverflow, and can resume from the position it last processed.
+type Subscription struct {
+	d      *Dispatcher
+	name   string
+	filter SubscriptionFilter
+	ch     chan *Envelope
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	lock       sync.Mutex
+	live       []*Envelope
+	queued     map[datastore.Key]struct{}
+	max        int
+	overflowed bool
+	signal     chan struct{}
+}
+
+// Subscribe streams the events matching filter stamped after from, and then all matching live events,
+// until ctx is done or the subscription is closed. Pass the zero Timestamp to start from the beginning.
+func (d *Dispatcher) Subscribe(ctx context.Context, from Timestamp, filter SubscriptionFilter) *Subscription {
+	return d.subscribe(ctx, "", from, filter)
+}
+
+// SubscribeNamed is like Subscribe, but resumes from the position last acknowledged with Ack by a
+// subscription of the same name, which is persisted in the store.
+func (d *Dispatcher) SubscribeNamed(ctx context.Context, name string, filter SubscriptionFilter) (*Subscription, error) {
+	from, err := d.Cursor(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.subscribe(ctx, name, from, filter), nil
+}
+
+// Cursor returns the last position acknowledged by the named subscription, or the zero Timestamp.
+func (d *Dispatcher) Cursor(name string) (Timestamp, error) {
+	b, err := d.store.Get(cursorKey(name))
+	if err == datastore.ErrNotFound {
+		return Timestamp{}, nil
+	} else if err != nil {
+		return Timestamp{}, err
+	}
+	ts, err := decodeStamp(b)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("cursor `%s`: %w", name, err)
+	}
+	return ts, nil
+}
+
+func (d *Dispatcher) subscribe(ctx context.Context, name string, from Timestamp, filter SubscriptionFilter) *Subscription {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{
+		d:      d,
+		name:   name,
+		filter: filter,
+		ch:     make(chan *Envelope),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		queued: make(map[datastore.Key]struct{}),
+		max:    d.subscriptionBuffer,
+		signal: make(chan struct{}, 1),
+	}
+	// Start buffering live events before reading history, so that none fall in between
+	d.lock.Lock()
+	d.subscriptions[s] = struct{}{}
+	d.lock.Unlock()
+	go s.run(from)
+	return s
+}
+
+// Channel returns the channel that receives events. It is closed when the subscription ends.
+func (s *Subscription) Channel() <-chan *Envelope {
+	return s.ch
+}
+
+// Ack records that all events up to and including stamp have been processed. For named subscriptions,
+// the position is persisted, so that a later SubscribeNamed resumes right after it.
+func (s *Subscription) Ack(stamp Timestamp) error {
+	select {
+	case <-s.done:
+		return ErrSubscriptionClosed
+	default:
+	}
+	if s.name == "" {
+		return nil
+	}
+	return s.d.store.Put(cursorKey(s.name), encodeStamp(stamp))
+}
+
+// Err returns the error, if any, that ended the subscription.
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close ends the subscription and waits for its channel to be closed.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// push buffers a live event. It is called with the dispatcher lock held, and never blocks. Once the
+// buffer is full, it is released and the subscription ends with ErrSubscriptionOverflow.
+func (s *Subscription) push(env *Envelope) {
+	if !s.filter.match(env.Event) {
+		return
+	}
+	s.lock.Lock()
+	if s.overflowed {
+		s.lock.Unlock()
+		return
+	}
+	if len(s.live) >= s.max {
+		s.overflowed = true
+		s.live, s.queued = nil, nil
+		s.lock.Unlock()
+		s.cancel()
+		return
+	}
+	s.live = append(s.live, env)
+	s.queued[env.Key().Key()] = struct{}{}
+	s.lock.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next buffered live event, if any.
+func (s *Subscription) pop() *Envelope {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.live) == 0 {
+		return nil
+	}
+	env := s.live[0]
+	s.live[0] = nil
+	s.live = s.live[1:]
+	delete(s.queued, env.Key().Key())
+	return env
+}
+
+// isQueued reports whether env is waiting in the live buffer.
+func (s *Subscription) isQueued(env *Envelope) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.queued[env.Key().Key()]
+	return ok
+}
+
+func (s *Subscription) run(from Timestamp) {
+	defer func() {
+		s.d.lock.Lock()
+		delete(s.d.subscriptions, s)
+		s.d.lock.Unlock()
+		s.lock.Lock()
+		if s.overflowed {
+			s.err = ErrSubscriptionOverflow
+		}
+		s.lock.Unlock()
+		close(s.ch)
+		close(s.done)
+	}()
+	q := s.d.Events().OfType(s.filter.Type).ForEntity(s.filter.EntityID)
+	if !from.IsZero() {
+		q = q.After(from)
+	}
+	it := q.Iter(s.ctx)
+	defer it.Close()
+	// Reading pages under the dispatcher lock means every event read was either stored before the
+	// subscription started, or is already in the live buffer
+	it.locker = &s.d.lock
+	for it.Next() {
+		// Events stored since the subscription started are delivered from the live buffer instead
+		if s.isQueued(it.Event()) {
+			continue
+		}
+		if !s.send(it.Event()) {
+			return
+		}
+	}
+	if err := it.Err(); err != nil {
+		if err != context.Canceled && err != context.DeadlineExceeded {
+			s.err = err
+		}
+		return
+	}
+	for {
+		for env := s.pop(); env != nil; env = s.pop() {
+			if !s.send(env) {
+				return
+			}
+		}
+		select {
+		case <-s.signal:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Subscription) send(env *Envelope) bool {
+	select {
+	case s.ch <- env:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+func cursorKey(name string) datastore.Key {
+	return datastore.NewKey(cursorsNamespace).ChildString(KeyVersion).ChildString(escapeSegment(name))
+}
+
+func encodeStamp(ts Timestamp) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint64(b, uint64(ts.Wall))
+	binary.BigEndian.PutUint32(b[8:], ts.Logical)
+	binary.BigEndian.PutUint64(b[12:], ts.Node)
+	return b
+}
+
+func decodeStamp(b []byte) (Timestamp, error) {
+	if len(b) != 20 {
+		return Timestamp{}, errors.New("invalid timestamp encoding")
+	}
+	return Timestamp{
+		Wall:    int64(binary.BigEndian.Uint64(b)),
+		Logical: binary.BigEndian.Uint32(b[8:]),
+		Node:    binary.BigEndian.Uint64(b[12:]),
+	}, nil
+}
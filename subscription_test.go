@@ -0,0 +1,141 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, s *Subscription, n int) []*Envelope {
+	var envs []*Envelope
+	for i := 0; i < n; i++ {
+		select {
+		case env, ok := <-s.Channel():
+			if !ok {
+				t.Fatalf("subscription closed after %d events", i)
+			}
+			envs = append(envs, env)
+		case <-time.After(time.Second):
+			t.Fatalf("receive timed out after %d events", i)
+		}
+	}
+	return envs
+}
+
+func TestSubscribe(t *testing.T) {
+	dispatcher, clk := setupEvents(t)
+	s := dispatcher.Subscribe(context.Background(), Timestamp{}, SubscriptionFilter{EntityID: "a"})
+	defer s.Close()
+	history := receive(t, s, 4)
+	for _, env := range history {
+		if env.Event.EntityID() != "a" {
+			t.Errorf("unexpected entity %s", env.Event.EntityID())
+		}
+	}
+	for _, id := range []string{"b", "a"} {
+		if err := dispatcher.Dispatch(&testEvent{ID: id, Kind: "Live", Timestamp: clk.Now()}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	live := receive(t, s, 1)
+	if live[0].Event.Type() != "Live" || live[0].Event.EntityID() != "a" {
+		t.Error("expected live event for entity a")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, ok := <-s.Channel(); ok {
+		t.Error("expected channel to be closed")
+	}
+	if len(dispatcher.subscriptions) != 0 {
+		t.Error("expected subscription to be removed")
+	}
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := dispatcher.Subscribe(ctx, Timestamp{Wall: int64(6 * time.Second), Node: dispatcher.Clock().Node()}, SubscriptionFilter{})
+	envs := receive(t, s, 6)
+	if envs[0].Stamp.Wall != int64(7*time.Second) {
+		t.Errorf("unexpected first stamp %s", envs[0].Stamp)
+	}
+	cancel()
+	if err := s.Err(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestSubscribeNamed(t *testing.T) {
+	dispatcher, _ := setupEvents(t)
+	s, err := dispatcher.SubscribeNamed(context.Background(), "worker", SubscriptionFilter{Type: "Created"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	envs := receive(t, s, 3)
+	if err := s.Ack(envs[2].Stamp); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	s.Close()
+	if err := s.Ack(envs[2].Stamp); err != ErrSubscriptionClosed {
+		t.Error("expected subscription closed error")
+	}
+	// A restarted consumer resumes right after the acknowledged position
+	restarted := NewDispatcher(dispatcher.Store())
+	s, err = restarted.SubscribeNamed(context.Background(), "worker", SubscriptionFilter{Type: "Created"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer s.Close()
+	resumed := receive(t, s, 3)
+	if resumed[0].Stamp.Wall != int64(7*time.Second) {
+		t.Errorf("unexpected first stamp %s", resumed[0].Stamp)
+	}
+}
+
+func TestSubscribeNoGaps(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore())
+	n := 250
+	dispatchN := func() {
+		for i := 0; i < n; i++ {
+			if err := dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Created", Timestamp: time.Now()}); err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+		}
+	}
+	dispatchN()
+	s := dispatcher.Subscribe(context.Background(), Timestamp{}, SubscriptionFilter{})
+	defer s.Close()
+	go dispatchN()
+	seen := make(map[Timestamp]struct{})
+	for _, env := range receive(t, s, 2*n) {
+		if _, ok := seen[env.Stamp]; ok {
+			t.Fatalf("duplicate event %s", env.Stamp)
+		}
+		seen[env.Stamp] = struct{}{}
+	}
+	select {
+	case env := <-s.Channel():
+		t.Errorf("unexpected extra event %s", env.Stamp)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	dispatcher := NewDispatcher(NewTxMapDatastore(), WithSubscriptionBuffer(2))
+	s := dispatcher.Subscribe(context.Background(), Timestamp{}, SubscriptionFilter{})
+	// The consumer does not read, so live events pile up in the buffer
+	for i := 0; i < 5; i++ {
+		if err := dispatcher.Dispatch(&testEvent{ID: "a", Kind: "Created", Timestamp: time.Now()}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if err := s.Err(); err != ErrSubscriptionOverflow {
+		t.Errorf("expected subscription overflow, got %v", err)
+	}
+	for range s.Channel() {
+	}
+	if len(dispatcher.subscriptions) != 0 {
+		t.Error("expected subscription to be removed")
+	}
+}
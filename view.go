@@ -1,6 +1,7 @@
 package eventstore
 
 import (
+	"context"
 	"errors"
 
 	datastore "github.com/ipfs/go-datastore"
@@ -15,32 +16,107 @@ type Reducer interface {
 }
 
 // ViewModel does some stuff...
-type ViewModel interface {
+type ViewModel = TypedViewModel[interface{}]
+
+// TypedViewModel is a ViewModel whose change notifications are of type T. The filtered listeners only
+// receive, queue, and drop the notifications they select (see TypedMemoryModel).
+type TypedViewModel[T any] interface {
 	Reducer
-	Listen() *broadcast.Listener
+	Listen() *broadcast.Listener[T]
+	ListenFiltered(predicate func(T) bool) *broadcast.Listener[T]
+	ListenKey(key string) *broadcast.Listener[T]
+	ListenPrefix(prefix string) *broadcast.Listener[T]
 }
 
 // MemoryModel does stuff...
-type MemoryModel struct {
-	broadcaster *broadcast.Broadcaster
+type MemoryModel = TypedMemoryModel[interface{}]
+
+// TypedMemoryModel is a MemoryModel whose change notifications are of type T.
+type TypedMemoryModel[T any] struct {
+	broadcaster *broadcast.Broadcaster[T]
+}
+
+// NewTypedMemoryModel creates a TypedMemoryModel whose change notifications are buffered up to capacity.
+// Options configure the underlying broadcaster, e.g., broadcast.WithLatest lets new listeners start from
+// the latest change.
+func NewTypedMemoryModel[T any](capacity int, opts ...broadcast.Option) *TypedMemoryModel[T] {
+	return &TypedMemoryModel[T]{
+		broadcaster: broadcast.NewBroadcaster[T](capacity, opts...),
+	}
 }
 
 // Reduce does stuff...
-func (m *MemoryModel) Reduce(event Event) error {
+func (m *TypedMemoryModel[T]) Reduce(event Event) error {
 	return errors.New("not implemented")
 }
 
 // Listen does stuff...
-func (m *MemoryModel) Listen() *broadcast.Listener {
+func (m *TypedMemoryModel[T]) Listen() *broadcast.Listener[T] {
 	return m.broadcaster.Listen()
 }
 
+// ListenContext returns a listener that is discarded when ctx is done.
+func (m *TypedMemoryModel[T]) ListenContext(ctx context.Context) *broadcast.Listener[T] {
+	return m.broadcaster.ListenContext(ctx)
+}
+
+// ListenFiltered returns a listener that only receives the change notifications for which predicate
+// returns true.
+func (m *TypedMemoryModel[T]) ListenFiltered(predicate func(T) bool) *broadcast.Listener[T] {
+	return m.broadcaster.ListenFiltered(predicate)
+}
+
+// ListenKey returns a listener that only receives the change notifications about key, e.g., an entity ID.
+// Notifications must implement broadcast.Topic, as Change does.
+func (m *TypedMemoryModel[T]) ListenKey(key string) *broadcast.Listener[T] {
+	return m.broadcaster.ListenTopic(key)
+}
+
+// ListenPrefix returns a listener that only receives the change notifications about keys starting with
+// prefix. Notifications must implement broadcast.Topic, as Change does.
+func (m *TypedMemoryModel[T]) ListenPrefix(prefix string) *broadcast.Listener[T] {
+	return m.broadcaster.ListenTopicPrefix(prefix)
+}
+
+// Notify sends a change notification to all listeners.
+func (m *TypedMemoryModel[T]) Notify(change T) error {
+	return m.broadcaster.Send(change)
+}
+
+// Change is a ViewModel change notification about the view entry stored under Key.
+type Change struct {
+	Key   string
+	Value interface{}
+}
+
+// Topic implements broadcast.Topic, so that changes can be listened to per key or key prefix.
+func (c Change) Topic() string {
+	return c.Key
+}
+
+// StatefulViewModel is a ViewModel that can list its current entries, as changes, e.g., to initialize
+// clients before streaming further changes to them.
+type StatefulViewModel interface {
+	ViewModel
+	// State returns the entries whose keys start with prefix.
+	State(ctx context.Context, prefix string) ([]Change, error)
+}
+
 // StoredModel does stuff...
 type StoredModel struct {
 	*MemoryModel
 	store datastore.Datastore
 }
 
+// NewStoredModel creates a StoredModel keeping its entries in store, and whose change notifications are
+// buffered up to capacity.
+func NewStoredModel(store datastore.Datastore, capacity int, opts ...broadcast.Option) *StoredModel {
+	return &StoredModel{
+		MemoryModel: NewTypedMemoryModel[interface{}](capacity, opts...),
+		store:       store,
+	}
+}
+
 // Store returns the internal view store.
 func (m StoredModel) Store() datastore.Datastore {
 	return m.store
@@ -56,6 +132,33 @@ func (m StoredModel) Query(query query.Query) ([]query.Entry, error) {
 	return result.Rest()
 }
 
+// QueryIter is a streaming version of Query, which reads entries from the store as the returned iterator is advanced.
+func (m StoredModel) QueryIter(ctx context.Context, query query.Query) (*Iterator, error) {
+	result, err := m.store.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return newIterator(ctx, result), nil
+}
+
+// State returns the entries of the internal view store whose keys start with prefix, in key order, with
+// their raw values.
+func (m StoredModel) State(ctx context.Context, prefix string) ([]Change, error) {
+	it, err := m.QueryIter(ctx, query.Query{Prefix: prefix, Orders: []query.Order{query.OrderByKey{}}})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var changes []Change
+	for it.Next() {
+		changes = append(changes, Change{Key: it.Entry().Key, Value: it.Entry().Value})
+	}
+	return changes, it.Err()
+}
+
 // Sanity check
 var _ ViewModel = (*MemoryModel)(nil)
 var _ ViewModel = (*StoredModel)(nil)
+var _ StatefulViewModel = (*StoredModel)(nil)
+var _ TypedViewModel[Event] = (*TypedMemoryModel[Event])(nil)
+var _ broadcast.Topic = Change{}
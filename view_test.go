@@ -1,11 +1,14 @@
 package eventstore
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
 	datastore "github.com/ipfs/go-datastore"
 	"github.com/textileio/go-eventstore/broadcast"
+	"github.com/textileio/go-eventstore/broadcast/broadcasttest"
 )
 
 // MapModel implements StoredModel using a map.
@@ -13,7 +16,7 @@ func NewMapModel() *MapModel {
 	return &MapModel{
 		StoredModel: &StoredModel{
 			MemoryModel: &MemoryModel{
-				broadcaster: &broadcast.Broadcaster{},
+				broadcaster: &broadcast.Broadcaster[interface{}]{},
 			},
 			store: datastore.NewMapDatastore(),
 		},
@@ -76,4 +79,76 @@ func TestNewStoredModel(t *testing.T) {
 	}
 }
 
+func TestTypedMemoryModel(t *testing.T) {
+	viewmodel := NewTypedMemoryModel[Event](1)
+	var _ TypedViewModel[Event] = viewmodel
+	l := viewmodel.Listen()
+	defer l.Discard()
+	event := &nullEvent{Timestamp: time.Now()}
+	if err := viewmodel.Notify(event); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if change := <-l.Channel(); change.EntityID() != "null" {
+		t.Errorf("unexpected change %v", change)
+	}
+}
+
+func TestListenKey(t *testing.T) {
+	viewmodel := NewTypedMemoryModel[Change](2)
+	broadcasttest.VerifyNoLeaks(t, viewmodel.broadcaster)
+	key := viewmodel.ListenKey("a")
+	prefix := viewmodel.ListenPrefix("b")
+	defer key.Discard()
+	defer prefix.Discard()
+	for _, k := range []string{"a", "ab", "b"} {
+		if err := viewmodel.Notify(Change{Key: k}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if change := <-key.Channel(); change.Key != "a" {
+		t.Errorf("unexpected change %v", change)
+	}
+	if change := <-prefix.Channel(); change.Key != "b" {
+		t.Errorf("unexpected change %v", change)
+	}
+	select {
+	case change := <-key.Channel():
+		t.Errorf("unexpected change %v", change)
+	default:
+	}
+}
+
+func TestMemoryModelLatest(t *testing.T) {
+	viewmodel := NewTypedMemoryModel[Change](1, broadcast.WithLatest())
+	if err := viewmodel.Notify(Change{Key: "a", Value: 1}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	l := viewmodel.Listen()
+	defer l.Discard()
+	if change := <-l.Channel(); change.Value != 1 {
+		t.Errorf("unexpected change %v", change)
+	}
+}
+
+func TestStoredModelState(t *testing.T) {
+	viewmodel := NewStoredModel(datastore.NewMapDatastore(), 1)
+	for _, k := range []string{"/a/1", "/a/2", "/b/1"} {
+		if err := viewmodel.Store().Put(datastore.NewKey(k), []byte(k)); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	state, err := viewmodel.State(context.Background(), "/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(state) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(state))
+	}
+	for _, change := range state {
+		if !strings.HasPrefix(change.Key, "/a/") || string(change.Value.([]byte)) != change.Key {
+			t.Errorf("unexpected entry %v", change)
+		}
+	}
+}
+
 // @todo: More tests!